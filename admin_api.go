@@ -0,0 +1,132 @@
+package dockworker
+
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+)
+
+// AdminAPI is an api for operating the server
+type AdminAPI struct {
+	jobManager       JobManager
+	garbageCollector GarbageCollector
+	cacheManager     CacheManager
+}
+
+// DrainStatus describes whether the server is draining
+type DrainStatus struct {
+	Draining bool `json:"draining"`
+	Running  int  `json:"running"`
+	Queued   int  `json:"queued"`
+}
+
+// NewAdminAPI creates a new AdminAPI
+func NewAdminAPI(jobManager JobManager, garbageCollector GarbageCollector, cacheManager CacheManager) AdminAPI {
+	return AdminAPI{
+		jobManager:       jobManager,
+		garbageCollector: garbageCollector,
+		cacheManager:     cacheManager,
+	}
+}
+
+// Register registers the admin api's routes
+func (api AdminAPI) Register(container *restful.Container) {
+	ws := new(restful.WebService)
+	ws.Path("/admin").
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("/drain").To(api.drainStatus).
+		Operation("drainStatus").
+		Writes(DrainStatus{}))
+
+	ws.Route(ws.POST("/drain").To(api.startDraining).
+		Operation("startDraining").
+		Writes(DrainStatus{}))
+
+	ws.Route(ws.DELETE("/drain").To(api.stopDraining).
+		Operation("stopDraining").
+		Writes(DrainStatus{}))
+
+	ws.Route(ws.GET("/gc").To(api.gcStatus).
+		Operation("gcStatus").
+		Writes(GCStatus{}))
+
+	ws.Route(ws.POST("/gc").To(api.collectGarbage).
+		Operation("collectGarbage").
+		Writes(CleanupReport{}))
+
+	ws.Route(ws.GET("/caches").To(api.listCaches).
+		Operation("listCaches").
+		Writes([]CacheVolume{}))
+
+	ws.Route(ws.DELETE("/caches/{name}").To(api.evictCache).
+		Operation("evictCache").
+		Param(ws.PathParameter("name", "name of the cache to evict every key of")).
+		Writes([]CacheVolume{}))
+
+	ws.Route(ws.DELETE("/caches/{name}/{key}").To(api.evictCache).
+		Operation("evictCacheKey").
+		Param(ws.PathParameter("name", "name of the cache")).
+		Param(ws.PathParameter("key", "key to evict")).
+		Writes([]CacheVolume{}))
+
+	container.Add(ws)
+}
+
+func (api AdminAPI) drainStatus(request *restful.Request, response *restful.Response) {
+	response.WriteHeaderAndEntity(http.StatusOK, api.currentDrainStatus())
+}
+
+func (api AdminAPI) startDraining(request *restful.Request, response *restful.Response) {
+	api.jobManager.SetDraining(true)
+	response.WriteHeaderAndEntity(http.StatusOK, api.currentDrainStatus())
+}
+
+func (api AdminAPI) stopDraining(request *restful.Request, response *restful.Response) {
+	api.jobManager.SetDraining(false)
+	response.WriteHeaderAndEntity(http.StatusOK, api.currentDrainStatus())
+}
+
+func (api AdminAPI) gcStatus(request *restful.Request, response *restful.Response) {
+	response.WriteHeaderAndEntity(http.StatusOK, api.garbageCollector.Status())
+}
+
+func (api AdminAPI) collectGarbage(request *restful.Request, response *restful.Response) {
+	response.WriteHeaderAndEntity(http.StatusOK, api.garbageCollector.Collect())
+}
+
+func (api AdminAPI) listCaches(request *restful.Request, response *restful.Response) {
+	caches, err := api.cacheManager.List()
+	if err != nil {
+		logAndRespondError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, caches)
+}
+
+func (api AdminAPI) evictCache(request *restful.Request, response *restful.Response) {
+	evicted, err := api.cacheManager.Evict(request.PathParameter("name"), request.PathParameter("key"))
+	if err != nil {
+		switch err {
+		case ErrCacheNotFound:
+			logAndRespondError(response, http.StatusNotFound, err)
+			return
+		case ErrCacheInUse:
+			logAndRespondError(response, http.StatusConflict, err)
+			return
+		default:
+			logAndRespondError(response, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, evicted)
+}
+
+func (api AdminAPI) currentDrainStatus() DrainStatus {
+	stats := api.jobManager.QueueStats()
+	return DrainStatus{
+		Draining: stats.Draining,
+		Running:  stats.Running,
+		Queued:   stats.Queued,
+	}
+}
@@ -23,9 +23,9 @@ var apiTestCases = []testCase{
 		job: Job{
 			ImageName: "ubuntu:14.04",
 			Cmds: []Cmd{
-				[]string{"sh", "-c", "echo \"test\" > /test.txt"},
-				[]string{"sleep", "1"},
-				[]string{"cat", "/test.txt"},
+				Cmd{Args: []string{"sh", "-c", "echo \"test\" > /test.txt"}},
+				Cmd{Args: []string{"sleep", "1"}},
+				Cmd{Args: []string{"cat", "/test.txt"}},
 			},
 			Results: []CmdResult{0, 0, 0},
 		},
@@ -48,10 +48,10 @@ var apiTestCases = []testCase{
 		job: Job{
 			ImageName: "ubuntu:14.04",
 			Cmds: []Cmd{
-				[]string{"sh", "-c", "echo \"test\" > /test.txt"},
-				[]string{"sleep", "1"},
-				[]string{"cat", "/notthere.txt"},
-				[]string{"echo", "'I shouldn't run"},
+				Cmd{Args: []string{"sh", "-c", "echo \"test\" > /test.txt"}},
+				Cmd{Args: []string{"sleep", "1"}},
+				Cmd{Args: []string{"cat", "/notthere.txt"}},
+				Cmd{Args: []string{"echo", "'I shouldn't run"}},
 			},
 			Results: []CmdResult{0, 0, 1},
 		},
@@ -71,7 +71,7 @@ var apiTestCases = []testCase{
 		job: Job{
 			ImageName: "ubuntu:14.04",
 			Cmds: []Cmd{
-				[]string{"notacommand"},
+				Cmd{Args: []string{"notacommand"}},
 			},
 		},
 		resultStatus:  JobStatusError,
@@ -95,8 +95,8 @@ var apiTestCases = []testCase{
 		job: Job{
 			ImageName: "ubuntu:14.04",
 			Cmds: []Cmd{
-				[]string{"sh", "-c", "echo $TEST_VAR1"},
-				[]string{"sh", "-c", "echo $TEST_VAR2"},
+				Cmd{Args: []string{"sh", "-c", "echo $TEST_VAR1"}},
+				Cmd{Args: []string{"sh", "-c", "echo $TEST_VAR2"}},
 			},
 			Env: map[string]string{
 				"TEST_VAR1": "test value 1",
@@ -125,8 +125,8 @@ var apiTestCases = []testCase{
 		job: Job{
 			ImageName: "doesnotexist",
 			Cmds: []Cmd{
-				[]string{"echo", "$TEST_VAR1"},
-				[]string{"echo", "$TEST_VAR2"},
+				Cmd{Args: []string{"echo", "$TEST_VAR1"}},
+				Cmd{Args: []string{"echo", "$TEST_VAR2"}},
 			},
 			Env: map[string]string{
 				"TEST_VAR1": "test value 1",
@@ -137,4 +137,50 @@ var apiTestCases = []testCase{
 		numContainers: 0,
 		numImages:     0,
 	},
+	testCase{
+		requestBody: `{
+	  "image": "ubuntu:14.04",
+	  "cmds": [
+	    ["echo", "Sleeping..."],
+	    ["sleep", "30"]
+	  ],
+		"timeout": 5,
+		"webhook_url": "%s"
+	}`,
+		job: Job{
+			ImageName: "ubuntu:14.04",
+			Cmds: []Cmd{
+				Cmd{Args: []string{"echo", "Sleeping..."}},
+				Cmd{Args: []string{"sleep", "30"}},
+			},
+			Timeout: 5,
+			Results: []CmdResult{0, 137},
+		},
+		resultStatus:  JobStatusTimedOut,
+		numContainers: 2,
+		numImages:     1,
+		logs:          "Sleeping...\n",
+	},
+	testCase{
+		requestBody: `{
+	  "image": "ubuntu:14.04",
+	  "cmds": [
+	    ["echo", "Sleeping..."],
+	    {"args": ["sleep", "30"], "timeout": 1}
+	  ],
+		"webhook_url": "%s"
+	}`,
+		job: Job{
+			ImageName: "ubuntu:14.04",
+			Cmds: []Cmd{
+				Cmd{Args: []string{"echo", "Sleeping..."}},
+				Cmd{Args: []string{"sleep", "30"}, Timeout: 1},
+			},
+			Results: []CmdResult{0, 137},
+		},
+		resultStatus:  JobStatusTimedOut,
+		numContainers: 2,
+		numImages:     1,
+		logs:          "Sleeping...\n",
+	},
 }
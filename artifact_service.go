@@ -0,0 +1,218 @@
+package dockworker
+
+import (
+	"archive/tar"
+	"io"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ArtifactType is the kind of file an artifact is
+type ArtifactType string
+
+const (
+	// ArtifactTypeFile is a regular file
+	ArtifactTypeFile ArtifactType = "file"
+	// ArtifactTypeDir is a directory
+	ArtifactTypeDir ArtifactType = "dir"
+	// ArtifactTypeLink is a symbolic or hard link
+	ArtifactTypeLink ArtifactType = "link"
+)
+
+// ArtifactFile is a file in the artifacts of a job
+type ArtifactFile struct {
+	// Path is where the file was in the container
+	Path string       `json:"path"`
+	Type ArtifactType `json:"type"`
+	Size int64        `json:"size"`
+	// artifact is the path of the artifact the file is in
+	artifact string
+}
+
+// ArtifactService handles the artifacts of jobs
+type ArtifactService interface {
+	List(ID JobID) ([]ArtifactFile, error)
+	// Find returns the file at the path, or ErrArtifactNotFound
+	Find(ID JobID, path string) (ArtifactFile, error)
+	// Write writes the contents of a regular file, or
+	// a tar archive of anything else, to the output
+	Write(ID JobID, file ArtifactFile, output io.Writer) error
+}
+
+// NewArtifactService returns a new ArtifactService
+func NewArtifactService(artifactStore ArtifactStore) ArtifactService {
+	return artifactService{
+		artifactStore: artifactStore,
+	}
+}
+
+type artifactService struct {
+	artifactStore ArtifactStore
+}
+
+func (as artifactService) List(ID JobID) ([]ArtifactFile, error) {
+	files := []ArtifactFile{}
+	err := as.walk(ID, func(file ArtifactFile, header *tar.Header, r io.Reader) (bool, error) {
+		files = append(files, file)
+		return true, nil
+	})
+	return files, err
+}
+
+func (as artifactService) Find(ID JobID, p string) (ArtifactFile, error) {
+	p = path.Clean("/" + p)
+	found := ArtifactFile{}
+	ok := false
+	err := as.walk(ID, func(file ArtifactFile, header *tar.Header, r io.Reader) (bool, error) {
+		if file.Path == p {
+			found = file
+			ok = true
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return ArtifactFile{}, err
+	}
+	if !ok {
+		return ArtifactFile{}, ErrArtifactNotFound
+	}
+	return found, nil
+}
+
+func (as artifactService) Write(ID JobID, file ArtifactFile, output io.Writer) error {
+	r, err := as.artifactStore.Open(ID, file.artifact)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if file.Type == ArtifactTypeFile {
+		return eachArtifactFile(file.artifact, r, func(f ArtifactFile, header *tar.Header, r io.Reader) (bool, error) {
+			if f.Path != file.Path {
+				return true, nil
+			}
+			_, err := io.Copy(output, r)
+			return false, err
+		})
+	}
+
+	// archive everything under the path, named relative
+	// to its parent like Docker does when copying it
+	tw := tar.NewWriter(output)
+	parent := path.Dir(file.Path)
+	err = eachArtifactFile(file.artifact, r, func(f ArtifactFile, header *tar.Header, r io.Reader) (bool, error) {
+		if f.Path != file.Path && !strings.HasPrefix(f.Path, strings.TrimSuffix(file.Path, "/")+"/") {
+			return true, nil
+		}
+		h := *header
+		h.Name = strings.TrimPrefix(strings.TrimPrefix(f.Path, parent), "/")
+		if f.Type == ArtifactTypeDir {
+			h.Name += "/"
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			return false, err
+		}
+		_, err := io.Copy(tw, r)
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// walk calls fn with every file of every artifact of the job until it returns false
+func (as artifactService) walk(ID JobID, fn func(file ArtifactFile, header *tar.Header, r io.Reader) (bool, error)) error {
+	paths, err := as.artifactStore.Paths(ID)
+	if err != nil {
+		return err
+	}
+	for _, artifact := range paths {
+		r, err := as.artifactStore.Open(ID, artifact)
+		if err != nil {
+			return err
+		}
+		more := true
+		err = eachArtifactFile(artifact, r, func(file ArtifactFile, header *tar.Header, r io.Reader) (bool, error) {
+			var fnErr error
+			more, fnErr = fn(file, header, r)
+			return more, fnErr
+		})
+		r.Close()
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachArtifactFile calls fn with every file in the archive of the artifact until it returns false
+func eachArtifactFile(artifact string, r io.Reader, fn func(file ArtifactFile, header *tar.Header, r io.Reader) (bool, error)) error {
+	// Docker names the files in the archive
+	// relative to the parent of the artifact
+	parent := path.Dir(artifact)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		file := ArtifactFile{
+			Path:     path.Join(parent, header.Name),
+			Type:     ArtifactTypeFile,
+			Size:     header.Size,
+			artifact: artifact,
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			file.Type = ArtifactTypeDir
+		case tar.TypeSymlink, tar.TypeLink:
+			file.Type = ArtifactTypeLink
+		}
+		more, err := fn(file, header, tr)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// collectArtifacts copies the artifacts of the job out of
+// the container into the store, replacing any collected
+// during an earlier attempt
+func collectArtifacts(client Runtime, artifactStore ArtifactStore, job Job, container string) {
+	if err := artifactStore.Delete(job.ID); err != nil {
+		log.Errorf("Error removing earlier artifacts of job %d: %s", job.ID, err)
+		return
+	}
+	for _, artifact := range job.Artifacts {
+		if err := collectArtifact(client, artifactStore, job.ID, artifact, container); err != nil {
+			log.Warnf("Failed to collect artifact %s of job %d from container %s: %s", artifact, job.ID, container, err)
+		}
+	}
+}
+
+func collectArtifact(client Runtime, artifactStore ArtifactStore, ID JobID, artifact string, container string) error {
+	w, err := artifactStore.Create(ID, artifact)
+	if err != nil {
+		return err
+	}
+	err = client.DownloadFromContainer(container, docker.DownloadFromContainerOptions{
+		Path:         artifact,
+		OutputStream: w,
+	})
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// don't keep half an artifact around
+		artifactStore.Remove(ID, artifact)
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package dockworker
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	artifactFileSuffix = ".tar"
+)
+
+// ArtifactStore keeps the artifacts copied out of job containers. Each
+// artifact is stored as the tar archive Docker gives for its path.
+type ArtifactStore interface {
+	// Create starts the artifact at the path over,
+	// returning a writer to store its archive with
+	Create(ID JobID, path string) (io.WriteCloser, error)
+	// Open returns a reader of the archive of the artifact
+	// at the path, or ErrArtifactNotFound if there is none
+	Open(ID JobID, path string) (io.ReadCloser, error)
+	// Paths returns the paths of the stored artifacts of the job
+	Paths(ID JobID) ([]string, error)
+	// Remove removes the artifact at the path
+	Remove(ID JobID, path string) error
+	// Delete removes all the artifacts of the job
+	Delete(ID JobID) error
+}
+
+// NewFileArtifactStore creates an ArtifactStore which keeps the
+// artifacts of each job in its own directory under the given one
+func NewFileArtifactStore(dir string) (ArtifactStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create artifact directory %s: %s", dir, err)
+	}
+	return fileArtifactStore{
+		dir: dir,
+	}, nil
+}
+
+type fileArtifactStore struct {
+	dir string
+}
+
+func (store fileArtifactStore) Create(ID JobID, path string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(store.jobDir(ID), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(store.path(ID, path))
+}
+
+func (store fileArtifactStore) Open(ID JobID, path string) (io.ReadCloser, error) {
+	f, err := os.Open(store.path(ID, path))
+	if os.IsNotExist(err) {
+		return nil, ErrArtifactNotFound
+	}
+	return f, err
+}
+
+func (store fileArtifactStore) Paths(ID JobID) ([]string, error) {
+	files, err := ioutil.ReadDir(store.jobDir(ID))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), artifactFileSuffix)
+		path, err := hex.DecodeString(name)
+		if err != nil || name == f.Name() {
+			// not one of ours
+			continue
+		}
+		paths = append(paths, string(path))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (store fileArtifactStore) Remove(ID JobID, path string) error {
+	err := os.Remove(store.path(ID, path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (store fileArtifactStore) Delete(ID JobID) error {
+	return os.RemoveAll(store.jobDir(ID))
+}
+
+func (store fileArtifactStore) jobDir(ID JobID) string {
+	return filepath.Join(store.dir, strconv.Itoa(int(ID)))
+}
+
+func (store fileArtifactStore) path(ID JobID, path string) string {
+	// paths in the container can have any characters,
+	// so they're hex encoded to make safe file names
+	return filepath.Join(store.jobDir(ID), hex.EncodeToString([]byte(path))+artifactFileSuffix)
+}
@@ -0,0 +1,125 @@
+package dockworker
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// storeArtifact stores an archive of the files like Docker
+// gives for the artifact, with names relative to its parent
+func storeArtifact(t *testing.T, store ArtifactStore, ID JobID, artifact string, files map[string]string) {
+	w, err := store.Create(ID, artifact)
+	assert.NoError(t, err)
+	tw := tar.NewWriter(w)
+	for _, name := range sortedKeys(files) {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))}
+		if name[len(name)-1] == '/' {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+			header.Size = 0
+		}
+		assert.NoError(t, tw.WriteHeader(header))
+		tw.Write([]byte(files[name]))
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, w.Close())
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	// directories sort before what's in them
+	sort.Strings(keys)
+	return keys
+}
+
+func TestFileArtifactStore(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+	store, err := NewFileArtifactStore(dir)
+	assert.NoError(t, err)
+
+	_, err = store.Open(1, "/out/report.txt")
+	assert.Equal(t, ErrArtifactNotFound, err, "Artifacts which were never stored shouldn't be found")
+	paths, err := store.Paths(1)
+	assert.NoError(t, err)
+	assert.Empty(t, paths)
+
+	storeArtifact(t, store, 1, "/out/report.txt", map[string]string{"report.txt": "passed"})
+	storeArtifact(t, store, 1, "/build", map[string]string{"build/": ""})
+	paths, err = store.Paths(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/build", "/out/report.txt"}, paths)
+
+	r, err := store.Open(1, "/out/report.txt")
+	assert.NoError(t, err)
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.NotEmpty(t, data)
+
+	assert.NoError(t, store.Remove(1, "/build"))
+	assert.NoError(t, store.Remove(1, "/build"), "Removing a missing artifact shouldn't fail")
+	paths, _ = store.Paths(1)
+	assert.Equal(t, []string{"/out/report.txt"}, paths)
+
+	assert.NoError(t, store.Delete(1))
+	paths, _ = store.Paths(1)
+	assert.Empty(t, paths, "Artifacts should be gone once deleted")
+}
+
+func TestArtifactService(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+	store, _ := NewFileArtifactStore(dir)
+	storeArtifact(t, store, 1, "/out/report.txt", map[string]string{"report.txt": "passed"})
+	storeArtifact(t, store, 1, "/build", map[string]string{
+		"build/":         "",
+		"build/app":      "binary",
+		"build/lib/":     "",
+		"build/lib/a.so": "library",
+	})
+	service := NewArtifactService(store)
+
+	files, err := service.List(1)
+	assert.NoError(t, err)
+	paths := []string{}
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, []string{"/build", "/build/app", "/build/lib", "/build/lib/a.so", "/out/report.txt"}, paths)
+	assert.Equal(t, ArtifactTypeDir, files[0].Type)
+	assert.Equal(t, int64(6), files[1].Size)
+
+	_, err = service.Find(1, "build/missing")
+	assert.Equal(t, ErrArtifactNotFound, err)
+
+	file, err := service.Find(1, "out/report.txt")
+	assert.NoError(t, err)
+	output := &bytes.Buffer{}
+	assert.NoError(t, service.Write(1, file, output))
+	assert.Equal(t, "passed", output.String(), "A file should be written as it is")
+
+	file, err = service.Find(1, "/build/lib/")
+	assert.NoError(t, err)
+	assert.Equal(t, ArtifactTypeDir, file.Type)
+	output.Reset()
+	assert.NoError(t, service.Write(1, file, output))
+	tr := tar.NewReader(output)
+	names := []string{}
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name)
+	}
+	assert.Equal(t, []string{"lib/", "lib/a.so"}, names, "A directory should be archived relative to its parent")
+}
@@ -0,0 +1,99 @@
+package dockworker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pborman/uuid"
+)
+
+// BlobID identifies an uploaded blob
+type BlobID string
+
+// Blob describes an uploaded blob
+type Blob struct {
+	ID   BlobID `json:"id"`
+	Size int64  `json:"size"`
+}
+
+// BlobStore keeps the tar archives uploaded for jobs to use as inputs
+type BlobStore interface {
+	// Create stores everything read from the reader as a new blob
+	Create(r io.Reader) (Blob, error)
+	// Open returns a reader of the blob,
+	// or ErrBlobNotFound if there is none
+	Open(ID BlobID) (io.ReadCloser, error)
+	Exists(ID BlobID) (bool, error)
+	Delete(ID BlobID) error
+}
+
+// NewFileBlobStore creates a BlobStore which keeps
+// each blob in its own file in the given directory
+func NewFileBlobStore(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create blob directory %s: %s", dir, err)
+	}
+	return fileBlobStore{
+		dir: dir,
+	}, nil
+}
+
+type fileBlobStore struct {
+	dir string
+}
+
+func (store fileBlobStore) Create(r io.Reader) (Blob, error) {
+	ID := BlobID(uuid.New())
+	f, err := os.Create(store.path(ID))
+	if err != nil {
+		return Blob{}, err
+	}
+	size, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(store.path(ID))
+		return Blob{}, err
+	}
+	return Blob{ID: ID, Size: size}, nil
+}
+
+func (store fileBlobStore) Open(ID BlobID) (io.ReadCloser, error) {
+	if uuid.Parse(string(ID)) == nil {
+		return nil, ErrBlobNotFound
+	}
+	f, err := os.Open(store.path(ID))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (store fileBlobStore) Exists(ID BlobID) (bool, error) {
+	if uuid.Parse(string(ID)) == nil {
+		return false, nil
+	}
+	_, err := os.Stat(store.path(ID))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (store fileBlobStore) Delete(ID BlobID) error {
+	if uuid.Parse(string(ID)) == nil {
+		return ErrBlobNotFound
+	}
+	err := os.Remove(store.path(ID))
+	if os.IsNotExist(err) {
+		return ErrBlobNotFound
+	}
+	return err
+}
+
+func (store fileBlobStore) path(ID BlobID) string {
+	return filepath.Join(store.dir, string(ID)+".tar")
+}
@@ -0,0 +1,209 @@
+package dockworker
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ImageCleanup is which of the images committed
+// for a job are removed once the job is done
+type ImageCleanup string
+
+const (
+	// ImageCleanupNone keeps every image
+	ImageCleanupNone ImageCleanup = "none"
+	// ImageCleanupIntermediate removes every image but the last one
+	// committed. Docker won't remove an image another one is built
+	// on, so the images the last one is built on are left until the
+	// garbage collector removes them along with it.
+	ImageCleanupIntermediate ImageCleanup = "intermediate"
+	// ImageCleanupAll removes every image
+	ImageCleanupAll ImageCleanup = "all"
+)
+
+func parseImageCleanup(v string) (ImageCleanup, error) {
+	switch ImageCleanup(v) {
+	case "", ImageCleanupNone:
+		return ImageCleanupNone, nil
+	case ImageCleanupIntermediate, ImageCleanupAll:
+		return ImageCleanup(v), nil
+	}
+	return "", fmt.Errorf("Invalid image cleanup %q", v)
+}
+
+// CleanupReport lists what a cleanup removed
+type CleanupReport struct {
+	Time       time.Time   `json:"time"`
+	Jobs       []JobID     `json:"jobs"`
+	Containers []Container `json:"containers"`
+	Images     []ImageName `json:"images"`
+	Errors     []string    `json:"errors"`
+}
+
+func newCleanupReport() *CleanupReport {
+	return &CleanupReport{
+		Time:       time.Now(),
+		Jobs:       []JobID{},
+		Containers: []Container{},
+		Images:     []ImageName{},
+		Errors:     []string{},
+	}
+}
+
+func (report *CleanupReport) addError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	log.Warn(msg)
+	report.Errors = append(report.Errors, msg)
+}
+
+func (report *CleanupReport) addJob(ID JobID) {
+	for _, j := range report.Jobs {
+		if j == ID {
+			return
+		}
+	}
+	report.Jobs = append(report.Jobs, ID)
+}
+
+// cleaner removes the containers and images of jobs
+// which are done, recording what's gone in the job
+type cleaner struct {
+	client     Runtime
+	jobUpdater JobUpdater
+}
+
+// removeContainers removes every container of the job which is left.
+// Only containers labelled with the job's ID are removed, so a bad
+// record can't take anything else with it.
+func (c cleaner) removeContainers(job *Job, report *CleanupReport) {
+	for _, container := range allContainers(*job) {
+		if containsContainer(job.RemovedContainers, container) {
+			continue
+		}
+		info, err := c.client.InspectContainer(string(container))
+		if err == nil && !labelledWithJob(info.Config, job.ID) {
+			report.addError("Not removing container %s of job %d, it doesn't belong to the job", container, job.ID)
+			continue
+		}
+		if _, ok := err.(*docker.NoSuchContainer); err != nil && !ok {
+			report.addError("Failed to inspect container %s of job %d: %s", container, job.ID, err)
+			continue
+		}
+		err = c.client.RemoveContainer(docker.RemoveContainerOptions{
+			ID:            string(container),
+			RemoveVolumes: true,
+			Force:         true,
+		})
+		if err != nil {
+			if _, ok := err.(*docker.NoSuchContainer); !ok {
+				report.addError("Failed to remove container %s of job %d: %s", container, job.ID, err)
+				continue
+			}
+			// someone beat us to it
+		}
+		log.Debugf("Removed container %s of job %d", container, job.ID)
+		c.jobUpdater.AddRemovedContainer(job, container)
+		report.Containers = append(report.Containers, container)
+		report.addJob(job.ID)
+	}
+}
+
+// removeImages removes every image of the job which is left except
+// for keep. Images are removed newest first, since Docker won't
+// remove an image while another one is built on it. Only images
+// committed from the job's containers, which carry over their
+// labels, are removed.
+func (c cleaner) removeImages(job *Job, keep ImageName, report *CleanupReport) {
+	images := allImages(*job)
+	for i := len(images) - 1; i >= 0; i-- {
+		image := images[i]
+		if image == keep || containsImage(job.RemovedImages, image) {
+			continue
+		}
+		info, err := c.client.InspectImage(string(image))
+		if err == nil && !labelledWithJob(info.Config, job.ID) {
+			report.addError("Not removing image %s of job %d, it wasn't committed by the job", image, job.ID)
+			continue
+		}
+		if err != nil && err != docker.ErrNoSuchImage {
+			report.addError("Failed to inspect image %s of job %d: %s", image, job.ID, err)
+			continue
+		}
+		err = c.client.RemoveImage(string(image))
+		if err != nil && err != docker.ErrNoSuchImage {
+			if keep != "" {
+				// the kept image is likely built on this one
+				log.Debugf("Not removing image %s of job %d: %s", image, job.ID, err)
+				continue
+			}
+			report.addError("Failed to remove image %s of job %d: %s", image, job.ID, err)
+			continue
+		}
+		log.Debugf("Removed image %s of job %d", image, job.ID)
+		c.jobUpdater.AddRemovedImage(job, image)
+		report.Images = append(report.Images, image)
+		report.addJob(job.ID)
+	}
+}
+
+// labelledWithJob returns whether the config of a
+// container or image has the label of the job's ID
+func labelledWithJob(config *docker.Config, ID JobID) bool {
+	return config != nil && config.Labels[JobIDLabel] == strconv.Itoa(int(ID))
+}
+
+// hasLeftovers returns whether any container or image of the job is left
+func hasLeftovers(job Job) bool {
+	for _, container := range allContainers(job) {
+		if !containsContainer(job.RemovedContainers, container) {
+			return true
+		}
+	}
+	for _, image := range allImages(job) {
+		if !containsImage(job.RemovedImages, image) {
+			return true
+		}
+	}
+	return false
+}
+
+// allContainers returns the containers of every attempt of the job
+func allContainers(job Job) []Container {
+	containers := []Container{}
+	for _, attempt := range job.Attempts {
+		containers = append(containers, attempt.Containers...)
+	}
+	return append(containers, job.Containers...)
+}
+
+// allImages returns the images of every attempt
+// of the job, in the order they were committed
+func allImages(job Job) []ImageName {
+	images := []ImageName{}
+	for _, attempt := range job.Attempts {
+		images = append(images, attempt.Images...)
+	}
+	return append(images, job.Images...)
+}
+
+func containsContainer(containers []Container, container Container) bool {
+	for _, c := range containers {
+		if c == container {
+			return true
+		}
+	}
+	return false
+}
+
+func containsImage(images []ImageName, image ImageName) bool {
+	for _, i := range images {
+		if i == image {
+			return true
+		}
+	}
+	return false
+}
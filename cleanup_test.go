@@ -0,0 +1,75 @@
+package dockworker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseImageCleanup(t *testing.T) {
+	cleanup, err := parseImageCleanup("")
+	assert.NoError(t, err)
+	assert.Equal(t, ImageCleanupNone, cleanup, "Images should be kept by default")
+
+	cleanup, err = parseImageCleanup("intermediate")
+	assert.NoError(t, err)
+	assert.Equal(t, ImageCleanupIntermediate, cleanup)
+
+	_, err = parseImageCleanup("some")
+	assert.Error(t, err)
+}
+
+func TestHasLeftovers(t *testing.T) {
+	job := Job{
+		Containers: []Container{"c2"},
+		Images:     []ImageName{"i2"},
+		Attempts: []Attempt{
+			{Containers: []Container{"c1"}, Images: []ImageName{"i1"}},
+		},
+	}
+	assert.Equal(t, []Container{"c1", "c2"}, allContainers(job), "Containers of every attempt should be included")
+	assert.Equal(t, []ImageName{"i1", "i2"}, allImages(job), "Images should be in the order they were committed")
+	assert.True(t, hasLeftovers(job))
+
+	job.RemovedContainers = []Container{"c1", "c2"}
+	job.RemovedImages = []ImageName{"i2"}
+	assert.True(t, hasLeftovers(job), "An image of an earlier attempt is left")
+
+	job.RemovedImages = append(job.RemovedImages, "i1")
+	assert.False(t, hasLeftovers(job))
+}
+
+func TestJobUpdaterAddRemoved(t *testing.T) {
+	store := NewJobStore()
+	job, _ := store.Add(Job{Containers: []Container{"c1"}, Images: []ImageName{"i1"}})
+	updater := NewJobUpdater(store, NewJobEventBus(10))
+
+	updater.AddRemovedContainer(&job, "c1")
+	updater.AddRemovedContainer(&job, "c1")
+	updater.AddRemovedImage(&job, "i1")
+
+	stored, _ := store.Find(job.ID)
+	assert.Equal(t, []Container{"c1"}, stored.RemovedContainers, "Removing a container twice should record it once")
+	assert.Equal(t, []ImageName{"i1"}, stored.RemovedImages)
+	assert.Equal(t, stored.RemovedContainers, job.RemovedContainers, "Job should be updated too")
+}
+
+func TestJobUpdaterRequeue(t *testing.T) {
+	store := NewJobStore()
+	job, _ := store.Add(Job{Status: JobStatusRunning, Containers: []Container{"c1", "c2"}, Images: []ImageName{"i1"}})
+	updater := NewJobUpdater(store, NewJobEventBus(10))
+
+	assert.NoError(t, updater.Requeue(&job, "Re-queued after restart"))
+	stored, _ := store.Find(job.ID)
+	assert.Equal(t, JobStatusQueued, stored.Status)
+	assert.Equal(t, 0, len(stored.Containers))
+	if assert.Equal(t, 1, len(stored.Attempts), "The interrupted run should be kept as an attempt") {
+		assert.Equal(t, "Re-queued after restart", stored.Attempts[0].Message)
+	}
+	assert.Equal(t, []Container{"c1", "c2"}, allContainers(stored), "Containers of the interrupted run should still be cleaned up")
+	assert.Equal(t, []ImageName{"i1"}, allImages(stored), "Images of the interrupted run should still be cleaned up")
+
+	assert.NoError(t, updater.Requeue(&job, "Re-queued after restart"))
+	stored, _ = store.Find(job.ID)
+	assert.Equal(t, 1, len(stored.Attempts), "A run which left nothing behind shouldn't be kept")
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/bbokorney/dockworker"
 )
@@ -15,9 +17,20 @@ import (
 type Client interface {
 	BaseURL() string
 	CreateJob(job dockworker.Job) (dockworker.Job, error)
+	// UploadBlob uploads a tar archive for jobs to use as an input
+	UploadBlob(archive io.Reader) (dockworker.Blob, error)
 	GetJob(ID dockworker.JobID) (dockworker.Job, error)
+	ListJobs(filter dockworker.JobFilter) (dockworker.JobList, error)
 	StopJob(ID dockworker.JobID) error
 	GetLogs(ID dockworker.JobID) ([]byte, error)
+	GetCmdLogs(ID dockworker.JobID, index int) ([]byte, error)
+	// StreamLogs follows the logs of the job as it runs, the
+	// returned reader ends once the job is done
+	StreamLogs(ID dockworker.JobID) (io.ReadCloser, error)
+	ListArtifacts(ID dockworker.JobID) ([]dockworker.ArtifactFile, error)
+	// GetArtifact returns the contents of the file at the path,
+	// or a tar archive of it if it's a directory
+	GetArtifact(ID dockworker.JobID, path string) ([]byte, error)
 }
 
 // TODO: Move into dockworker package so the imports make more sense
@@ -65,6 +78,21 @@ func (c client) CreateJob(job dockworker.Job) (dockworker.Job, error) {
 	return *createdJob, nil
 }
 
+func (c client) UploadBlob(archive io.Reader) (dockworker.Blob, error) {
+	resp, err := http.Post(fmt.Sprintf("%s/blobs", c.baseURL), "application/x-tar", archive)
+	if err != nil {
+		return dockworker.Blob{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return dockworker.Blob{}, fmt.Errorf("Expected code %d but received %d", http.StatusCreated, resp.StatusCode)
+	}
+	blob := dockworker.Blob{}
+	err = json.NewDecoder(resp.Body).Decode(&blob)
+	return blob, err
+}
+
 func (c client) GetLogs(ID dockworker.JobID) ([]byte, error) {
 	resp, err := http.Get(fmt.Sprintf("%s/jobs/%d/logs", c.baseURL, ID))
 	if err != nil {
@@ -82,6 +110,60 @@ func (c client) GetLogs(ID dockworker.JobID) ([]byte, error) {
 	return respBody, err
 }
 
+func (c client) GetCmdLogs(ID dockworker.JobID, index int) ([]byte, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/jobs/%d/cmds/%d/logs", c.baseURL, ID, index))
+	if err != nil {
+		return []byte{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return []byte{}, fmt.Errorf("Expected code %d but received %d", http.StatusOK, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c client) StreamLogs(ID dockworker.JobID) (io.ReadCloser, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/jobs/%d/logs?follow=true", c.baseURL, ID))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Expected code %d but received %d", http.StatusOK, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func (c client) ListArtifacts(ID dockworker.JobID) ([]dockworker.ArtifactFile, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/jobs/%d/artifacts", c.baseURL, ID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Expected code %d but received %d", http.StatusOK, resp.StatusCode)
+	}
+	files := []dockworker.ArtifactFile{}
+	err = json.NewDecoder(resp.Body).Decode(&files)
+	return files, err
+}
+
+func (c client) GetArtifact(ID dockworker.JobID, path string) ([]byte, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/jobs/%d/artifacts/%s", c.baseURL, ID, strings.TrimPrefix(path, "/")))
+	if err != nil {
+		return []byte{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return []byte{}, fmt.Errorf("Expected code %d but received %d", http.StatusOK, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
 func (c client) StopJob(ID dockworker.JobID) error {
 	resp, err := http.Post(fmt.Sprintf("%s/jobs/%d/stop", c.baseURL, ID), "application/json", nil)
 	if err != nil {
@@ -97,3 +179,27 @@ func (c client) GetJob(ID dockworker.JobID) (dockworker.Job, error) {
 	// TODO: implement
 	return dockworker.Job{}, nil
 }
+
+func (c client) ListJobs(filter dockworker.JobFilter) (dockworker.JobList, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/jobs?%s", c.baseURL, filter.QueryValues().Encode()))
+	if err != nil {
+		return dockworker.JobList{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return dockworker.JobList{}, fmt.Errorf("Expected code %d but received %d", http.StatusOK, resp.StatusCode)
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return dockworker.JobList{}, err
+	}
+
+	jobs := &dockworker.JobList{}
+	err = json.Unmarshal(respBody, jobs)
+	if err != nil {
+		return dockworker.JobList{}, err
+	}
+	return *jobs, nil
+}
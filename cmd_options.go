@@ -0,0 +1,76 @@
+package dockworker
+
+import (
+	"github.com/fsouza/go-dockerclient"
+)
+
+// cmdOptions are the container options a command runs with
+type cmdOptions struct {
+	workdir    string
+	user       string
+	entrypoint []string
+	// resetEntrypoint is set when an image committed after an
+	// earlier command could have an entrypoint the job set
+	resetEntrypoint bool
+}
+
+// hasCmdOptions returns whether the job or any of its commands set options
+func hasCmdOptions(job Job) bool {
+	if job.Workdir != "" || job.User != "" || len(job.Entrypoint) > 0 {
+		return true
+	}
+	for _, cmd := range job.Cmds {
+		if cmd.Workdir != "" || cmd.User != "" || len(cmd.Entrypoint) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveCmdOptions returns the options of the command at the index,
+// taking any the command doesn't set from the job, and any the job
+// doesn't set from the image config the job started from
+func resolveCmdOptions(job Job, index int, image *docker.Config) cmdOptions {
+	opts := cmdOptions{}
+	if image != nil {
+		opts.workdir = image.WorkingDir
+		opts.user = image.User
+		opts.entrypoint = image.Entrypoint
+	}
+	cmd := job.Cmds[index]
+	opts.workdir = firstString(cmd.Workdir, job.Workdir, opts.workdir)
+	opts.user = firstString(cmd.User, job.User, opts.user)
+	if len(cmd.Entrypoint) > 0 {
+		opts.entrypoint = cmd.Entrypoint
+	} else if len(job.Entrypoint) > 0 {
+		opts.entrypoint = job.Entrypoint
+	}
+	for _, c := range job.Cmds[:index] {
+		if len(c.Entrypoint) > 0 {
+			opts.resetEntrypoint = true
+		}
+	}
+	return opts
+}
+
+// apply sets the options in the config of a container. Committed images
+// keep the options of the container they came from, so an option which
+// is empty is reset rather than left for the image to fill in.
+func (opts cmdOptions) apply(config *docker.Config) {
+	config.WorkingDir = opts.workdir
+	config.User = opts.user
+	config.Entrypoint = opts.entrypoint
+	if len(config.Entrypoint) == 0 && opts.resetEntrypoint {
+		// Docker takes a single empty argument as no entrypoint
+		config.Entrypoint = []string{""}
+	}
+}
+
+func firstString(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,198 @@
+package dockworker
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// EnvDataDir is the environment variable which sets
+	// the directory jobs are persisted in
+	EnvDataDir = "DOCKWORKER_DATA_DIR"
+	// EnvMaxRunningJobs is the environment variable which sets
+	// the maximum number of jobs which run at the same time
+	EnvMaxRunningJobs = "DOCKWORKER_MAX_RUNNING_JOBS"
+	// EnvShutdownGracePeriod is the environment variable which sets
+	// how many seconds running jobs get to finish during a shutdown
+	EnvShutdownGracePeriod = "DOCKWORKER_SHUTDOWN_GRACE_PERIOD"
+	// EnvEventHistory is the environment variable which sets how
+	// many job events are kept for clients to resume from
+	EnvEventHistory = "DOCKWORKER_EVENT_HISTORY"
+	// EnvLogDir is the environment variable which sets
+	// the directory the logs of jobs are archived in
+	EnvLogDir = "DOCKWORKER_LOG_DIR"
+	// EnvLogMaxBytes is the environment variable which sets
+	// how many bytes of logs are archived for each job
+	EnvLogMaxBytes = "DOCKWORKER_LOG_MAX_BYTES"
+	// EnvArtifactDir is the environment variable which sets
+	// the directory the artifacts of jobs are kept in
+	EnvArtifactDir = "DOCKWORKER_ARTIFACT_DIR"
+	// EnvBlobDir is the environment variable which sets
+	// the directory archives uploaded for jobs are kept in
+	EnvBlobDir = "DOCKWORKER_BLOB_DIR"
+	// EnvRemoveContainers is the environment variable which sets whether
+	// the containers of jobs are removed once their logs are archived
+	EnvRemoveContainers = "DOCKWORKER_REMOVE_CONTAINERS"
+	// EnvRemoveImages is the environment variable which sets which
+	// images committed for jobs are removed once they're done
+	EnvRemoveImages = "DOCKWORKER_REMOVE_IMAGES"
+	// EnvGCInterval is the environment variable which sets how many
+	// seconds apart the garbage collector runs
+	EnvGCInterval = "DOCKWORKER_GC_INTERVAL"
+	// EnvGCMaxAge is the environment variable which sets how many seconds
+	// after a job ends the garbage collector removes what it left
+	EnvGCMaxAge = "DOCKWORKER_GC_MAX_AGE"
+	// EnvGCMaxJobs is the environment variable which sets how many of the
+	// latest jobs which are done the garbage collector leaves alone
+	EnvGCMaxJobs = "DOCKWORKER_GC_MAX_JOBS"
+
+	// DefaultMaxRunningJobs is the maximum number of jobs
+	// which run at the same time if none is configured
+	DefaultMaxRunningJobs = 10
+	// DefaultShutdownGracePeriod is how long running jobs get to
+	// finish during a shutdown if no grace period is configured
+	DefaultShutdownGracePeriod = 60 * time.Second
+	// DefaultEventHistory is how many job events are kept for
+	// clients to resume from if no history size is configured
+	DefaultEventHistory = 1000
+	// DefaultLogMaxBytes is how many bytes of logs are archived
+	// for each job if no limit is configured
+	DefaultLogMaxBytes = 10 * 1024 * 1024
+)
+
+// Config holds the settings the server is started with
+type Config struct {
+	// DataDir is the directory jobs are persisted in.
+	// If empty, jobs are only kept in memory.
+	DataDir string
+	// MaxRunningJobs is the maximum number of jobs which run
+	// at the same time, the rest wait in the queue.
+	// Zero or less means there is no limit.
+	MaxRunningJobs int
+	// ShutdownGracePeriod is how long running jobs get to finish
+	// during a shutdown before they are stopped
+	ShutdownGracePeriod time.Duration
+	// EventHistory is how many of the latest job events are
+	// kept in memory for clients to resume streams from
+	EventHistory int
+	// LogDir is the directory the logs of jobs are archived in.
+	// If empty, it's the logs directory in the DataDir, and if
+	// that's empty too, logs aren't archived.
+	LogDir string
+	// LogMaxBytes is how many bytes of logs are archived for each
+	// job, after which they're truncated. Zero or less means no limit.
+	LogMaxBytes int64
+	// ArtifactDir is the directory the artifacts of jobs are kept in.
+	// If empty, it's the artifacts directory in the DataDir, and if
+	// that's empty too, jobs can't have artifacts.
+	ArtifactDir string
+	// BlobDir is the directory archives uploaded for jobs are kept in.
+	// If empty, it's the blobs directory in the DataDir, and if that's
+	// empty too, jobs can't have inputs.
+	BlobDir string
+	// RemoveContainers is whether the containers of jobs are
+	// removed once they're done. Only done if logs are archived.
+	RemoveContainers bool
+	// RemoveImages is which images committed for
+	// jobs are removed once they're done
+	RemoveImages ImageCleanup
+	// GCInterval is how often the garbage collector runs,
+	// zero or less means it only runs when asked to
+	GCInterval time.Duration
+	// GCMaxAge is how long after a job ends the garbage collector
+	// removes its containers and images, zero means no limit
+	GCMaxAge time.Duration
+	// GCMaxJobs is how many of the latest jobs which are done keep their
+	// containers and images when the garbage collector runs, zero means no limit
+	GCMaxJobs int
+	// ResourceLimits are the resources jobs get by
+	// default and the most they may ask for
+	ResourceLimits ResourceLimits
+	// MountPolicy is what jobs are allowed to mount
+	MountPolicy MountPolicy
+	// NetworkPolicy is which networking options jobs may use
+	NetworkPolicy NetworkPolicy
+	// RegistryAuth is the config.json style file of the registry
+	// credentials used for every job, none are used if it's empty
+	RegistryAuth string
+	// RegistryCredentialsDir is the directory of named registry
+	// credentials jobs may ask for, none can if it's empty
+	RegistryCredentialsDir string
+	// Runtime is what runs the commands of jobs, docker or process
+	Runtime string
+	// ProcessRuntime is how the process runtime runs commands
+	ProcessRuntime ProcessRuntimeConfig
+}
+
+// NewConfigFromEnv creates a Config from environment variables
+func NewConfigFromEnv() Config {
+	removeImages, err := parseImageCleanup(os.Getenv(EnvRemoveImages))
+	if err != nil {
+		log.Fatalf("Invalid value for %s, expected none, intermediate or all: %s", EnvRemoveImages, os.Getenv(EnvRemoveImages))
+	}
+	runtime := os.Getenv(EnvRuntime)
+	switch runtime {
+	case "":
+		runtime = RuntimeDocker
+	case RuntimeDocker, RuntimeProcess:
+	default:
+		log.Fatalf("Invalid value for %s, expected docker or process: %s", EnvRuntime, runtime)
+	}
+	dataDir := os.Getenv(EnvDataDir)
+	return Config{
+		DataDir:             dataDir,
+		MaxRunningJobs:      intFromEnv(EnvMaxRunningJobs, DefaultMaxRunningJobs),
+		ShutdownGracePeriod: secondsFromEnv(EnvShutdownGracePeriod, DefaultShutdownGracePeriod),
+		EventHistory:        intFromEnv(EnvEventHistory, DefaultEventHistory),
+		LogDir:              os.Getenv(EnvLogDir),
+		LogMaxBytes:         int64(intFromEnv(EnvLogMaxBytes, DefaultLogMaxBytes)),
+		ArtifactDir:         os.Getenv(EnvArtifactDir),
+		BlobDir:             os.Getenv(EnvBlobDir),
+		RemoveContainers:    boolFromEnv(EnvRemoveContainers, false),
+		RemoveImages:        removeImages,
+		GCInterval:          secondsFromEnv(EnvGCInterval, 0),
+		GCMaxAge:            secondsFromEnv(EnvGCMaxAge, 0),
+		GCMaxJobs:           intFromEnv(EnvGCMaxJobs, 0),
+		ResourceLimits: ResourceLimits{
+			Default: resourcesFromEnv(EnvDefaultResourcePrefix),
+			Max:     resourcesFromEnv(EnvMaxResourcePrefix),
+		},
+		MountPolicy:            mountPolicyFromEnv(),
+		NetworkPolicy:          networkPolicyFromEnv(),
+		RegistryAuth:           os.Getenv(EnvRegistryAuth),
+		RegistryCredentialsDir: os.Getenv(EnvRegistryCredentialsDir),
+		Runtime:                runtime,
+		ProcessRuntime:         processRuntimeConfigFromEnv(dataDir),
+	}
+}
+
+func intFromEnv(name string, defaultValue int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s, expected an integer: %s", name, v)
+	}
+	return i
+}
+
+func boolFromEnv(name string, defaultValue bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s, expected true or false: %s", name, v)
+	}
+	return b
+}
+
+func secondsFromEnv(name string, defaultValue time.Duration) time.Duration {
+	return time.Duration(intFromEnv(name, int(defaultValue/time.Second))) * time.Second
+}
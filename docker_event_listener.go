@@ -20,34 +20,53 @@ type DockerEventListener interface {
 // or maybe they're fine separate
 
 // NewEventListener returns a new EventListener
-func NewEventListener(client *docker.Client) DockerEventListener {
+func NewEventListener(client Runtime) DockerEventListener {
 	return &dockerEventListener{
 		client:    client,
 		lock:      &sync.RWMutex{},
 		listeners: make(map[chan *docker.APIEvents]bool),
+		quit:      make(chan bool),
 	}
 }
 
 type dockerEventListener struct {
 	lock      *sync.RWMutex
 	listeners map[chan *docker.APIEvents]bool
-	client    *docker.Client
+	client    Runtime
 	running   bool
 	eventChan chan *docker.APIEvents
+	quit      chan bool
 }
 
 func (el *dockerEventListener) Start() error {
-	// TODO: proper locking
+	el.lock.Lock()
+	defer el.lock.Unlock()
+	if el.running {
+		return nil
+	}
 	err := el.setupEventChan()
 	if err != nil {
 		return err
 	}
+	el.running = true
 	go el.eventWorker()
 	return nil
 }
 
 func (el *dockerEventListener) Stop() {
-	// TODO: implement
+	el.lock.Lock()
+	running := el.running
+	el.running = false
+	el.lock.Unlock()
+	if !running {
+		return
+	}
+	// the worker needs the lock to finish sending
+	// an event, so don't hold it while stopping it
+	el.quit <- true
+	if err := el.client.RemoveEventListener(el.eventChan); err != nil {
+		log.Warnf("Failed to remove event listener: %s", err)
+	}
 }
 
 func (el *dockerEventListener) RegisterListener(listener chan *docker.APIEvents) {
@@ -78,15 +97,21 @@ func (el *dockerEventListener) setupEventChan() error {
 
 func (el *dockerEventListener) eventWorker() {
 	for {
-		for event := range el.eventChan {
+		select {
+		case event, ok := <-el.eventChan:
+			if !ok {
+				log.Warn("Event chan closed, registering new one")
+				el.setupEventChan()
+				continue
+			}
 			el.lock.RLock()
 			for listener := range el.listeners {
 				go el.sendToListener(listener, event)
 			}
 			el.lock.RUnlock()
+		case <-el.quit:
+			return
 		}
-		log.Warn("Event chan closed, registering new one")
-		el.setupEventChan()
 	}
 }
 
@@ -10,8 +10,55 @@ var (
 	// ErrInvalidJobID indicates the job ID was
 	// specificed in an invalid format
 	ErrInvalidJobID = fmt.Errorf("Invalid job ID")
+
+	// ErrCmdNotFound indicates the job has no
+	// command with the specified index
+	ErrCmdNotFound = fmt.Errorf("No command with that index")
+
+	// ErrInvalidCmdIndex indicates the command index
+	// was specified in an invalid format
+	ErrInvalidCmdIndex = fmt.Errorf("Invalid command index")
+
+	// ErrLogsNotFound indicates no logs are
+	// stored for the specified container
+	ErrLogsNotFound = fmt.Errorf("No logs stored for that container")
+
+	// ErrArtifactNotFound indicates the job has
+	// no artifact at the specified path
+	ErrArtifactNotFound = fmt.Errorf("No artifact at that path")
+
+	// ErrBlobNotFound indicates the blob with
+	// the specified ID doesn't exist
+	ErrBlobNotFound = fmt.Errorf("Blob not found")
+
+	// ErrCacheNotFound indicates there is no
+	// volume of the specified cache and key
+	ErrCacheNotFound = fmt.Errorf("Cache not found")
+
+	// ErrCacheInUse indicates the volume of a cache can't
+	// be removed while a job has it mounted
+	ErrCacheInUse = fmt.Errorf("Cache is in use by a running job")
+
+	// ErrDraining indicates the server is draining
+	// and not accepting new jobs
+	ErrDraining = fmt.Errorf("Not accepting new jobs while draining")
 )
 
+// ValidationError indicates a submitted job is invalid
+type ValidationError struct {
+	msg string
+}
+
+func (e ValidationError) Error() string {
+	return e.msg
+}
+
+func validationErrorf(format string, a ...interface{}) error {
+	return ValidationError{
+		msg: fmt.Sprintf(format, a...),
+	}
+}
+
 func errorResponse(msg string) errorMessage {
 	return errorMessage{
 		Message: msg,
@@ -0,0 +1,813 @@
+package dockworker
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// fakeProgram is what runs in a fake container, chosen by the
+// first argument of its command. It returns the exit code.
+type fakeProgram func(p *fakeProcess) int
+
+// fakeProcess is a program running in a fake container
+type fakeProcess struct {
+	Args   []string
+	Stdout io.Writer
+	Stderr io.Writer
+	// Stopped is closed when the container is asked to stop
+	Stopped   <-chan bool
+	container *fakeContainer
+	runtime   *fakeRuntime
+}
+
+// ReadFile returns the contents of a file in the container
+func (p *fakeProcess) ReadFile(name string) ([]byte, bool) {
+	p.runtime.lock.Lock()
+	defer p.runtime.lock.Unlock()
+	data, ok := p.container.files[name]
+	return data, ok
+}
+
+// WriteFile writes a file in the container
+func (p *fakeProcess) WriteFile(name string, data []byte) {
+	p.runtime.lock.Lock()
+	defer p.runtime.lock.Unlock()
+	p.container.files[name] = data
+}
+
+// OOMKilled marks the container as killed for running out of memory
+func (p *fakeProcess) OOMKilled() {
+	p.runtime.lock.Lock()
+	defer p.runtime.lock.Unlock()
+	p.container.state.OOMKilled = true
+}
+
+// fakePrograms are the programs every fake runtime has
+var fakePrograms = map[string]fakeProgram{
+	"true": func(p *fakeProcess) int { return 0 },
+	"echo": func(p *fakeProcess) int {
+		fmt.Fprintln(p.Stdout, strings.Join(p.Args[1:], " "))
+		return 0
+	},
+	// exit code
+	"exit": func(p *fakeProcess) int {
+		code, _ := strconv.Atoi(p.Args[1])
+		return code
+	},
+	// write path contents...
+	"write": func(p *fakeProcess) int {
+		p.WriteFile(p.Args[1], []byte(strings.Join(p.Args[2:], " ")+"\n"))
+		return 0
+	},
+	// cat path
+	"cat": func(p *fakeProcess) int {
+		data, ok := p.ReadFile(p.Args[1])
+		if !ok {
+			fmt.Fprintf(p.Stderr, "cat: %s: No such file or directory\n", p.Args[1])
+			return 1
+		}
+		p.Stdout.Write(data)
+		return 0
+	},
+	// sleep seconds, which like sleep in a real container
+	// ignores being asked to stop, so it's killed instead
+	"sleep": func(p *fakeProcess) int {
+		seconds, _ := strconv.ParseFloat(p.Args[1], 64)
+		select {
+		case <-time.After(time.Duration(seconds * float64(time.Second))):
+			return 0
+		case <-p.Stopped:
+			return 137
+		}
+	},
+	"oom": func(p *fakeProcess) int {
+		p.OOMKilled()
+		return 137
+	},
+}
+
+// fakeHook changes what a method of the fake runtime does
+type fakeHook struct {
+	delay time.Duration
+	// errs are returned by the next calls, one each
+	errs []error
+}
+
+type fakeImage struct {
+	image *docker.Image
+	files map[string][]byte
+}
+
+type fakeContainer struct {
+	container *docker.Container
+	state     docker.State
+	files     map[string][]byte
+	logs      []fakeLogLine
+	// changed is closed and replaced whenever logs are
+	// written or the state changes, for followers to wait on
+	changed  chan bool
+	stopped  chan bool
+	done     chan bool
+	stopOnce sync.Once
+}
+
+type fakeLogLine struct {
+	stderr bool
+	time   time.Time
+	data   []byte
+}
+
+type fakeListener struct {
+	events  chan<- *docker.APIEvents
+	removed chan bool
+}
+
+// fakeRuntime is a Runtime which simulates Docker in memory, so the
+// whole server can be run in tests without a Docker daemon. Images are
+// pulled from its registry, which addImage adds to.
+type fakeRuntime struct {
+	lock       *sync.Mutex
+	programs   map[string]fakeProgram
+	registry   map[string]*fakeImage
+	images     map[string]*fakeImage
+	tags       map[string]string
+	containers map[string]*fakeContainer
+	volumes    map[string]bool
+	listeners  []*fakeListener
+	hooks      map[string]*fakeHook
+	// held are the statuses of events which aren't sent until released
+	held   map[string][]*docker.APIEvents
+	events chan *docker.APIEvents
+	nextID int
+}
+
+func newFakeRuntime() *fakeRuntime {
+	fr := &fakeRuntime{
+		lock:       &sync.Mutex{},
+		programs:   make(map[string]fakeProgram),
+		registry:   make(map[string]*fakeImage),
+		images:     make(map[string]*fakeImage),
+		tags:       make(map[string]string),
+		containers: make(map[string]*fakeContainer),
+		volumes:    make(map[string]bool),
+		hooks:      make(map[string]*fakeHook),
+		held:       make(map[string][]*docker.APIEvents),
+		events:     make(chan *docker.APIEvents, 1000),
+	}
+	for name, program := range fakePrograms {
+		fr.programs[name] = program
+	}
+	go fr.sendEvents()
+	return fr
+}
+
+// addImage adds an image with the given files to the registry
+func (fr *fakeRuntime) addImage(name string, config *docker.Config, files map[string][]byte) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	if config == nil {
+		config = &docker.Config{}
+	}
+	repository, tag, _ := splitImageName(name)
+	if tag == "" {
+		tag = "latest"
+	}
+	sum := sha256.Sum256([]byte(repository + ":" + tag))
+	digest := fmt.Sprintf("sha256:%x", sum)
+	image := &fakeImage{
+		image: &docker.Image{
+			ID:          fr.newID("image"),
+			Config:      config,
+			RepoDigests: []string{repository + "@" + digest},
+		},
+		files: files,
+	}
+	fr.registry[repository+":"+tag] = image
+	fr.registry[repository+"@"+digest] = image
+}
+
+// failNext makes the next call of the method return the error
+func (fr *fakeRuntime) failNext(method string, err error) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fr.hook(method).errs = append(fr.hook(method).errs, err)
+}
+
+// delay makes every call of the method take at least as long as the delay
+func (fr *fakeRuntime) delay(method string, delay time.Duration) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fr.hook(method).delay = delay
+}
+
+// holdEvents holds back events with the status until the
+// returned function is called, which sends them in order
+func (fr *fakeRuntime) holdEvents(status string) func() {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fr.held[status] = []*docker.APIEvents{}
+	return func() {
+		fr.lock.Lock()
+		defer fr.lock.Unlock()
+		for _, event := range fr.held[status] {
+			fr.events <- event
+		}
+		delete(fr.held, status)
+	}
+}
+
+func (fr *fakeRuntime) hook(method string) *fakeHook {
+	if fr.hooks[method] == nil {
+		fr.hooks[method] = &fakeHook{}
+	}
+	return fr.hooks[method]
+}
+
+// call applies the hook of the method, it must be called without the lock
+func (fr *fakeRuntime) call(method string) error {
+	fr.lock.Lock()
+	hook := fr.hook(method)
+	delay := hook.delay
+	var err error
+	if len(hook.errs) > 0 {
+		err, hook.errs = hook.errs[0], hook.errs[1:]
+	}
+	fr.lock.Unlock()
+	time.Sleep(delay)
+	return err
+}
+
+func (fr *fakeRuntime) newID(kind string) string {
+	fr.nextID++
+	return fmt.Sprintf("%s-%d", kind, fr.nextID)
+}
+
+// emit sends an event about the container, it must be called with the lock
+func (fr *fakeRuntime) emit(status, ID string) {
+	now := time.Now()
+	event := &docker.APIEvents{Status: status, ID: ID, Time: now.Unix(), TimeNano: now.UnixNano()}
+	if held, ok := fr.held[status]; ok {
+		fr.held[status] = append(held, event)
+		return
+	}
+	fr.events <- event
+}
+
+func (fr *fakeRuntime) sendEvents() {
+	for event := range fr.events {
+		fr.lock.Lock()
+		listeners := fr.listeners
+		fr.lock.Unlock()
+		for _, listener := range listeners {
+			select {
+			case listener.events <- event:
+			case <-listener.removed:
+			}
+		}
+	}
+}
+
+func (fr *fakeRuntime) Ping() error {
+	return fr.call("Ping")
+}
+
+func (fr *fakeRuntime) Info() (*docker.DockerInfo, error) {
+	if err := fr.call("Info"); err != nil {
+		return nil, err
+	}
+	return &docker.DockerInfo{Name: "fake-host", ServerVersion: "fake"}, nil
+}
+
+func (fr *fakeRuntime) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	if err := fr.call("PullImage"); err != nil {
+		return err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	tag := opts.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	name := opts.Repository + ":" + tag
+	if strings.HasPrefix(tag, "sha256:") {
+		name = opts.Repository + "@" + tag
+	}
+	image, ok := fr.registry[name]
+	if !ok {
+		return &docker.Error{Status: 404, Message: fmt.Sprintf("image %s not found", name)}
+	}
+	fr.images[image.image.ID] = image
+	fr.tags[name] = image.image.ID
+	return nil
+}
+
+// findImage returns the local image with the name or ID, it must be called with the lock
+func (fr *fakeRuntime) findImage(name string) (*fakeImage, bool) {
+	if image, ok := fr.images[name]; ok {
+		return image, true
+	}
+	if _, tag, digest := splitImageName(name); tag == "" && digest == "" {
+		name += ":latest"
+	}
+	image, ok := fr.images[fr.tags[name]]
+	return image, ok
+}
+
+func (fr *fakeRuntime) InspectImage(name string) (*docker.Image, error) {
+	if err := fr.call("InspectImage"); err != nil {
+		return nil, err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	image, ok := fr.findImage(name)
+	if !ok {
+		return nil, docker.ErrNoSuchImage
+	}
+	copied := *image.image
+	return &copied, nil
+}
+
+func (fr *fakeRuntime) RemoveImage(name string) error {
+	if err := fr.call("RemoveImage"); err != nil {
+		return err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	image, ok := fr.findImage(name)
+	if !ok {
+		return docker.ErrNoSuchImage
+	}
+	for _, other := range fr.images {
+		if other.image.Parent == image.image.ID {
+			return &docker.Error{Status: 409, Message: fmt.Sprintf("image %s has dependent child images", name)}
+		}
+	}
+	delete(fr.images, image.image.ID)
+	return nil
+}
+
+func (fr *fakeRuntime) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	if err := fr.call("CreateContainer"); err != nil {
+		return nil, err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	image, ok := fr.findImage(opts.Config.Image)
+	if !ok {
+		return nil, docker.ErrNoSuchImage
+	}
+	config := *opts.Config
+	c := &fakeContainer{
+		container: &docker.Container{
+			ID:         fr.newID("container"),
+			Created:    time.Now(),
+			Config:     &config,
+			Image:      image.image.ID,
+			HostConfig: opts.HostConfig,
+		},
+		files:   copyFiles(image.files),
+		changed: make(chan bool),
+		stopped: make(chan bool),
+		done:    make(chan bool),
+	}
+	if opts.HostConfig != nil {
+		for _, bind := range opts.HostConfig.Binds {
+			if source := strings.SplitN(bind, ":", 2)[0]; !path.IsAbs(source) {
+				fr.volumes[source] = true
+			}
+		}
+	}
+	fr.containers[c.container.ID] = c
+	fr.emit("create", c.container.ID)
+	return fr.inspect(c), nil
+}
+
+func (fr *fakeRuntime) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	if err := fr.call("StartContainer"); err != nil {
+		return err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	c, ok := fr.containers[id]
+	if !ok {
+		return &docker.NoSuchContainer{ID: id}
+	}
+	if c.state.Running {
+		return &docker.ContainerAlreadyRunning{ID: id}
+	}
+	args := c.container.Config.Cmd
+	if len(c.container.Config.Entrypoint) > 0 && c.container.Config.Entrypoint[0] != "" {
+		args = append(append([]string{}, c.container.Config.Entrypoint...), args...)
+	}
+	if len(args) == 0 || fr.programs[args[0]] == nil {
+		return &docker.Error{Status: 500, Message: fmt.Sprintf("exec: %q: executable file not found in $PATH", strings.Join(args, " "))}
+	}
+	c.state.Running = true
+	c.state.StartedAt = time.Now()
+	fr.changed(c)
+	fr.emit("start", id)
+
+	p := &fakeProcess{
+		Args:      args,
+		Stopped:   c.stopped,
+		container: c,
+		runtime:   fr,
+	}
+	stdout := &fakeLogWriter{runtime: fr, container: c}
+	stderr := &fakeLogWriter{runtime: fr, container: c, stderr: true}
+	p.Stdout, p.Stderr = stdout, stderr
+	go func(program fakeProgram) {
+		exitCode := program(p)
+		fr.lock.Lock()
+		defer fr.lock.Unlock()
+		stdout.flush()
+		stderr.flush()
+		c.state.Running = false
+		c.state.ExitCode = exitCode
+		c.state.FinishedAt = time.Now()
+		fr.changed(c)
+		close(c.done)
+		fr.emit("die", id)
+	}(fr.programs[args[0]])
+	return nil
+}
+
+// changed wakes up everything waiting on the container, it must be called with the lock
+func (fr *fakeRuntime) changed(c *fakeContainer) {
+	close(c.changed)
+	c.changed = make(chan bool)
+}
+
+func (fr *fakeRuntime) StopContainer(id string, timeout uint) error {
+	if err := fr.call("StopContainer"); err != nil {
+		return err
+	}
+	fr.lock.Lock()
+	c, ok := fr.containers[id]
+	if !ok {
+		fr.lock.Unlock()
+		return &docker.NoSuchContainer{ID: id}
+	}
+	if !c.state.Running {
+		fr.lock.Unlock()
+		return &docker.ContainerNotRunning{ID: id}
+	}
+	fr.lock.Unlock()
+	c.stopOnce.Do(func() { close(c.stopped) })
+	<-c.done
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fr.emit("stop", id)
+	return nil
+}
+
+func (fr *fakeRuntime) WaitContainer(id string) (int, error) {
+	if err := fr.call("WaitContainer"); err != nil {
+		return 0, err
+	}
+	fr.lock.Lock()
+	c, ok := fr.containers[id]
+	fr.lock.Unlock()
+	if !ok {
+		return 0, &docker.NoSuchContainer{ID: id}
+	}
+	<-c.done
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	return c.state.ExitCode, nil
+}
+
+// inspect returns a copy of the container, it must be called with the lock
+func (fr *fakeRuntime) inspect(c *fakeContainer) *docker.Container {
+	container := *c.container
+	container.State = c.state
+	return &container
+}
+
+func (fr *fakeRuntime) InspectContainer(id string) (*docker.Container, error) {
+	if err := fr.call("InspectContainer"); err != nil {
+		return nil, err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	c, ok := fr.containers[id]
+	if !ok {
+		return nil, &docker.NoSuchContainer{ID: id}
+	}
+	return fr.inspect(c), nil
+}
+
+func (fr *fakeRuntime) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	if err := fr.call("ListContainers"); err != nil {
+		return nil, err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	containers := []docker.APIContainers{}
+	for _, c := range fr.containers {
+		if !opts.All && !c.state.Running {
+			continue
+		}
+		if !hasLabels(c.container.Config.Labels, opts.Filters["label"]) {
+			continue
+		}
+		containers = append(containers, docker.APIContainers{
+			ID:      c.container.ID,
+			Image:   c.container.Image,
+			Created: c.container.Created.Unix(),
+			Labels:  c.container.Config.Labels,
+		})
+	}
+	return containers, nil
+}
+
+func (fr *fakeRuntime) CommitContainer(opts docker.CommitContainerOptions) (*docker.Image, error) {
+	if err := fr.call("CommitContainer"); err != nil {
+		return nil, err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	c, ok := fr.containers[opts.Container]
+	if !ok {
+		return nil, &docker.NoSuchContainer{ID: opts.Container}
+	}
+	config := *c.container.Config
+	image := &fakeImage{
+		image: &docker.Image{
+			ID:        fr.newID("image"),
+			Parent:    c.container.Image,
+			Container: c.container.ID,
+			Created:   time.Now(),
+			Config:    &config,
+		},
+		files: copyFiles(c.files),
+	}
+	fr.images[image.image.ID] = image
+	fr.emit("commit", c.container.ID)
+	copied := *image.image
+	return &copied, nil
+}
+
+func (fr *fakeRuntime) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	if err := fr.call("RemoveContainer"); err != nil {
+		return err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	c, ok := fr.containers[opts.ID]
+	if !ok {
+		return &docker.NoSuchContainer{ID: opts.ID}
+	}
+	if c.state.Running && !opts.Force {
+		return &docker.Error{Status: 409, Message: fmt.Sprintf("container %s is running", opts.ID)}
+	}
+	if c.state.Running {
+		c.stopOnce.Do(func() { close(c.stopped) })
+	}
+	delete(fr.containers, opts.ID)
+	fr.emit("destroy", opts.ID)
+	return nil
+}
+
+func (fr *fakeRuntime) UploadToContainer(id string, opts docker.UploadToContainerOptions) error {
+	if err := fr.call("UploadToContainer"); err != nil {
+		return err
+	}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(opts.InputStream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &docker.Error{Status: 400, Message: err.Error()}
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		data := &bytes.Buffer{}
+		if _, err := io.Copy(data, tr); err != nil {
+			return err
+		}
+		files[path.Join(opts.Path, header.Name)] = data.Bytes()
+	}
+
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	c, ok := fr.containers[id]
+	if !ok {
+		return &docker.NoSuchContainer{ID: id}
+	}
+	for name, data := range files {
+		c.files[name] = data
+	}
+	return nil
+}
+
+func (fr *fakeRuntime) DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error {
+	if err := fr.call("DownloadFromContainer"); err != nil {
+		return err
+	}
+	fr.lock.Lock()
+	c, ok := fr.containers[id]
+	if !ok {
+		fr.lock.Unlock()
+		return &docker.NoSuchContainer{ID: id}
+	}
+	// like Docker, entries are named from the last element of the path
+	dir := path.Clean(opts.Path)
+	files := make(map[string][]byte)
+	for name, data := range c.files {
+		if name == dir {
+			files[path.Base(dir)] = data
+		} else if strings.HasPrefix(name, dir+"/") {
+			files[path.Join(path.Base(dir), strings.TrimPrefix(name, dir+"/"))] = data
+		}
+	}
+	fr.lock.Unlock()
+	if len(files) == 0 {
+		return &docker.Error{Status: 404, Message: fmt.Sprintf("Could not find the file %s in container %s", opts.Path, id)}
+	}
+
+	tw := tar.NewWriter(opts.OutputStream)
+	for _, name := range sortedFileNames(files) {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (fr *fakeRuntime) Logs(opts docker.LogsOptions) error {
+	if err := fr.call("Logs"); err != nil {
+		return err
+	}
+	written := 0
+	for {
+		fr.lock.Lock()
+		c, ok := fr.containers[opts.Container]
+		if !ok {
+			fr.lock.Unlock()
+			return &docker.NoSuchContainer{ID: opts.Container}
+		}
+		lines := c.logs[written:]
+		running := c.state.Running
+		changed := c.changed
+		fr.lock.Unlock()
+
+		for _, line := range lines {
+			w := opts.OutputStream
+			if line.stderr {
+				w = opts.ErrorStream
+			}
+			if (line.stderr && !opts.Stderr) || (!line.stderr && !opts.Stdout) {
+				continue
+			}
+			data := line.data
+			if opts.Timestamps {
+				data = append([]byte(line.time.Format(time.RFC3339Nano)+" "), data...)
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		written += len(lines)
+		if !opts.Follow || !running {
+			return nil
+		}
+		<-changed
+	}
+}
+
+func (fr *fakeRuntime) ListVolumes(opts docker.ListVolumesOptions) ([]docker.Volume, error) {
+	if err := fr.call("ListVolumes"); err != nil {
+		return nil, err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	volumes := []docker.Volume{}
+	for name := range fr.volumes {
+		volumes = append(volumes, docker.Volume{Name: name, Driver: "local"})
+	}
+	return volumes, nil
+}
+
+func (fr *fakeRuntime) RemoveVolume(name string) error {
+	if err := fr.call("RemoveVolume"); err != nil {
+		return err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	if !fr.volumes[name] {
+		return docker.ErrNoSuchVolume
+	}
+	for _, c := range fr.containers {
+		if c.container.HostConfig == nil {
+			continue
+		}
+		for _, bind := range c.container.HostConfig.Binds {
+			if strings.SplitN(bind, ":", 2)[0] == name {
+				return docker.ErrVolumeInUse
+			}
+		}
+	}
+	delete(fr.volumes, name)
+	return nil
+}
+
+func (fr *fakeRuntime) AddEventListener(listener chan<- *docker.APIEvents) error {
+	if err := fr.call("AddEventListener"); err != nil {
+		return err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fr.listeners = append(fr.listeners, &fakeListener{events: listener, removed: make(chan bool)})
+	return nil
+}
+
+func (fr *fakeRuntime) RemoveEventListener(listener chan *docker.APIEvents) error {
+	if err := fr.call("RemoveEventListener"); err != nil {
+		return err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	listeners := []*fakeListener{}
+	for _, l := range fr.listeners {
+		if l.events == (chan<- *docker.APIEvents)(listener) {
+			close(l.removed)
+			continue
+		}
+		listeners = append(listeners, l)
+	}
+	fr.listeners = listeners
+	return nil
+}
+
+// fakeLogWriter records what a fake program writes, line by line
+type fakeLogWriter struct {
+	runtime   *fakeRuntime
+	container *fakeContainer
+	stderr    bool
+	partial   []byte
+}
+
+func (w *fakeLogWriter) Write(p []byte) (int, error) {
+	w.runtime.lock.Lock()
+	defer w.runtime.lock.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.add(w.partial[:i+1])
+		w.partial = w.partial[i+1:]
+	}
+}
+
+// flush records an unfinished last line, it must be called with the lock
+func (w *fakeLogWriter) flush() {
+	if len(w.partial) > 0 {
+		w.add(w.partial)
+		w.partial = nil
+	}
+}
+
+func (w *fakeLogWriter) add(data []byte) {
+	line := fakeLogLine{stderr: w.stderr, time: time.Now(), data: append([]byte{}, data...)}
+	w.container.logs = append(w.container.logs, line)
+	w.runtime.changed(w.container)
+}
+
+func copyFiles(files map[string][]byte) map[string][]byte {
+	copied := make(map[string][]byte)
+	for name, data := range files {
+		copied[name] = data
+	}
+	return copied
+}
+
+func sortedFileNames(files map[string][]byte) []string {
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,138 @@
+package dockworker
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// GarbageCollector periodically removes the containers
+// and images of jobs which are done and too old or too many
+type GarbageCollector interface {
+	Start()
+	Stop()
+	// Collect removes what's past the limits right away
+	Collect() CleanupReport
+	Status() GCStatus
+}
+
+// GCStatus describes the garbage collector's limits and its last run
+type GCStatus struct {
+	// Interval and MaxAge are in seconds
+	Interval   int            `json:"interval"`
+	MaxAge     int            `json:"max_age"`
+	MaxJobs    int            `json:"max_jobs"`
+	LastReport *CleanupReport `json:"last_report"`
+}
+
+// NewGarbageCollector returns a new GarbageCollector using the GC limits of the config
+func NewGarbageCollector(jobStore JobStore, client Runtime, jobUpdater JobUpdater, config Config) GarbageCollector {
+	return &garbageCollector{
+		jobStore: jobStore,
+		cleaner: cleaner{
+			client:     client,
+			jobUpdater: jobUpdater,
+		},
+		lock:     &sync.Mutex{},
+		interval: config.GCInterval,
+		maxAge:   config.GCMaxAge,
+		maxJobs:  config.GCMaxJobs,
+		quit:     make(chan bool),
+	}
+}
+
+type garbageCollector struct {
+	jobStore JobStore
+	cleaner  cleaner
+	// held while collecting, so only one collection runs at a time
+	lock       *sync.Mutex
+	interval   time.Duration
+	maxAge     time.Duration
+	maxJobs    int
+	lastReport *CleanupReport
+	running    bool
+	quit       chan bool
+}
+
+func (gc *garbageCollector) Start() {
+	if gc.interval <= 0 {
+		log.Info("Garbage collection of job containers and images is disabled")
+		return
+	}
+	gc.lock.Lock()
+	defer gc.lock.Unlock()
+	if gc.running {
+		return
+	}
+	gc.running = true
+	go gc.worker()
+}
+
+func (gc *garbageCollector) Stop() {
+	gc.lock.Lock()
+	running := gc.running
+	gc.running = false
+	gc.lock.Unlock()
+	if running {
+		gc.quit <- true
+	}
+}
+
+func (gc *garbageCollector) worker() {
+	ticker := time.NewTicker(gc.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			gc.Collect()
+		case <-gc.quit:
+			return
+		}
+	}
+}
+
+func (gc *garbageCollector) Collect() CleanupReport {
+	gc.lock.Lock()
+	defer gc.lock.Unlock()
+	report := newCleanupReport()
+	jobs, err := gc.jobStore.List(JobFilter{})
+	if err != nil {
+		report.addError("Failed to list jobs for garbage collection: %s", err)
+		gc.lastReport = report
+		return *report
+	}
+
+	done := []Job{}
+	for _, job := range jobs {
+		if jobDone(job) {
+			done = append(done, job)
+		}
+	}
+	for i, job := range done {
+		// jobs are listed oldest first
+		tooMany := gc.maxJobs > 0 && len(done)-i > gc.maxJobs
+		tooOld := gc.maxAge > 0 && !job.EndTime.IsZero() && time.Since(job.EndTime) > gc.maxAge
+		if !(tooMany || tooOld) || !hasLeftovers(job) {
+			continue
+		}
+		gc.cleaner.removeContainers(&job, report)
+		gc.cleaner.removeImages(&job, "", report)
+	}
+
+	log.Infof("Garbage collection removed %d containers and %d images of %d jobs",
+		len(report.Containers), len(report.Images), len(report.Jobs))
+	gc.lastReport = report
+	return *report
+}
+
+func (gc *garbageCollector) Status() GCStatus {
+	gc.lock.Lock()
+	defer gc.lock.Unlock()
+	return GCStatus{
+		Interval:   int(gc.interval / time.Second),
+		MaxAge:     int(gc.maxAge / time.Second),
+		MaxJobs:    gc.maxJobs,
+		LastReport: gc.lastReport,
+	}
+}
@@ -1,9 +1,12 @@
 package dockworker
 
 import (
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/emicklei/go-restful"
@@ -11,17 +14,50 @@ import (
 	"github.com/pborman/uuid"
 )
 
+const (
+	// how long to wait for webhook requests to be sent during a shutdown
+	webhookFlushTimeout = 30 * time.Second
+)
+
 // InitWSContainer sets up the program
 func InitWSContainer() *restful.Container {
 	log.SetLevel(log.DebugLevel)
-	jobAPI := initJobAPI()
+	config := NewConfigFromEnv()
+	jobAPI, adminAPI := initAPIs(config, initRuntime(config))
 	wsContainer := restful.NewContainer()
 	wsContainer.Filter(globalLogging)
 	jobAPI.Register(wsContainer)
+	adminAPI.Register(wsContainer)
 	return wsContainer
 }
 
-func initJobAPI() JobAPI {
+// initRuntime returns the runtime the config asks for, exiting if it can't be set up
+func initRuntime(config Config) Runtime {
+	if config.Runtime != RuntimeProcess {
+		return initDockerRuntime()
+	}
+	processConfig := config.ProcessRuntime
+	if processConfig.Dir == "" {
+		dir, err := ioutil.TempDir("", "dockworker-processes")
+		if err != nil {
+			log.Fatalf("Failed to create process directory: %s", err)
+		}
+		processConfig.Dir = dir
+	}
+	log.Infof("Running commands as processes in %s", processConfig.Dir)
+	if processConfig.Sandbox {
+		log.Info("Sandboxing processes in namespaces")
+	}
+	runtime, err := NewProcessRuntime(processConfig)
+	if err != nil {
+		log.Fatalf("Failed to set up process runtime: %s", err)
+	}
+	return runtime
+}
+
+// initDockerRuntime returns the runtime for the Docker daemon
+// set by the environment, exiting if it can't be reached
+func initDockerRuntime() Runtime {
 	client, err := docker.NewClientFromEnv()
 	if err != nil {
 		log.Fatalf("Error creating client %s", err)
@@ -31,32 +67,134 @@ func initJobAPI() JobAPI {
 	if err != nil {
 		log.Fatalf("Failed to ping Docker daemon: %s", err)
 	}
+	return NewDockerRuntime(client)
+}
+
+func initAPIs(config Config, client Runtime) (JobAPI, AdminAPI) {
 	eventListener := NewEventListener(client)
-	err = eventListener.Start()
+	err := eventListener.Start()
 	if err != nil {
 		log.Fatalf("Failed to start event listener: %s", err)
 	}
 	stopEventChan := make(chan JobID)
 	stopEventListener := NewStopEventListener(stopEventChan)
 	stopEventListener.Start()
-	jobStore := NewJobStore()
-	jobUpdater := NewJobUpdater(jobStore)
-	jobManager := NewJobManager(jobStore, client, eventListener, jobUpdater, stopEventListener)
+	jobStore := initJobStore(config)
+	eventBus := NewJobEventBus(config.EventHistory)
+	jobUpdater := NewJobUpdater(jobStore, eventBus)
+	webhookSender := NewWebhookSender()
+	logStore := initLogStore(config)
+	artifactStore := initArtifactStore(config)
+	blobStore := initBlobStore(config)
+	registryAuth := NewRegistryAuth(config.RegistryAuth, config.RegistryCredentialsDir)
+	jobManager := NewJobManager(jobStore, logStore, artifactStore, blobStore, registryAuth, client, eventListener, jobUpdater, stopEventListener, webhookSender, config)
 	jobManager.Start()
-	logService := NewLogService(jobStore, client)
-	jobService := NewJobService(jobStore, jobManager)
+	garbageCollector := NewGarbageCollector(jobStore, client, jobUpdater, config)
+	garbageCollector.Start()
+	logService := NewLogService(jobStore, logStore, client, eventBus)
+	jobService := NewJobService(jobStore, jobManager, artifactStore, blobStore, registryAuth, config)
 	stopService := NewStopService(stopEventChan)
-	// TODO: pass in everything which requires cleanup for a shutdown
-	signalHandler()
-	return NewJobAPI(jobService, logService, stopService)
+	signalHandler(func() {
+		garbageCollector.Stop()
+		jobManager.Stop()
+		if !webhookSender.Flush(webhookFlushTimeout) {
+			log.Warn("Gave up waiting for webhook requests to be sent")
+		}
+		stopEventListener.Stop()
+		eventListener.Stop()
+	})
+	var artifactService ArtifactService
+	if artifactStore != nil {
+		artifactService = NewArtifactService(artifactStore)
+	}
+	return NewJobAPI(jobService, logService, stopService, eventBus, artifactService, blobStore), NewAdminAPI(jobManager, garbageCollector, NewCacheManager(client))
+}
+
+func initJobStore(config Config) JobStore {
+	if config.DataDir == "" {
+		log.Info("No data directory set, jobs will only be kept in memory")
+		return NewJobStore()
+	}
+	log.Infof("Persisting jobs in %s", config.DataDir)
+	jobStore, err := NewFileJobStore(config.DataDir)
+	if err != nil {
+		log.Fatalf("Failed to open job store: %s", err)
+	}
+	return jobStore
+}
+
+// initLogStore returns the store to archive logs in,
+// or nil if there's nowhere to keep them
+func initLogStore(config Config) LogStore {
+	dir := config.LogDir
+	if dir == "" && config.DataDir != "" {
+		dir = filepath.Join(config.DataDir, "logs")
+	}
+	if dir == "" {
+		log.Info("No log or data directory set, logs will only be read from containers")
+		return nil
+	}
+	log.Infof("Archiving logs in %s", dir)
+	logStore, err := NewFileLogStore(dir)
+	if err != nil {
+		log.Fatalf("Failed to open log store: %s", err)
+	}
+	return logStore
+}
+
+// initArtifactStore returns the store to keep artifacts in,
+// or nil if there's nowhere to keep them
+func initArtifactStore(config Config) ArtifactStore {
+	dir := config.ArtifactDir
+	if dir == "" && config.DataDir != "" {
+		dir = filepath.Join(config.DataDir, "artifacts")
+	}
+	if dir == "" {
+		log.Info("No artifact or data directory set, jobs can't have artifacts")
+		return nil
+	}
+	log.Infof("Keeping artifacts in %s", dir)
+	artifactStore, err := NewFileArtifactStore(dir)
+	if err != nil {
+		log.Fatalf("Failed to open artifact store: %s", err)
+	}
+	return artifactStore
+}
+
+// initBlobStore returns the store to keep uploaded
+// archives in, or nil if there's nowhere to keep them
+func initBlobStore(config Config) BlobStore {
+	dir := config.BlobDir
+	if dir == "" && config.DataDir != "" {
+		dir = filepath.Join(config.DataDir, "blobs")
+	}
+	if dir == "" {
+		log.Info("No blob or data directory set, jobs can't have inputs")
+		return nil
+	}
+	log.Infof("Keeping uploaded blobs in %s", dir)
+	blobStore, err := NewFileBlobStore(dir)
+	if err != nil {
+		log.Fatalf("Failed to open blob store: %s", err)
+	}
+	return blobStore
 }
 
-func signalHandler() {
+// signalHandler runs shutdown and exits when the process is signalled.
+// A second signal exits straight away.
+func signalHandler(shutdown func()) {
 	go func() {
-		signalChannel := make(chan os.Signal)
+		signalChannel := make(chan os.Signal, 1)
 		signal.Notify(signalChannel, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 		sig := <-signalChannel
 		log.Infof("Shutting down from signal %s", sig)
+		go func() {
+			sig := <-signalChannel
+			log.Warnf("Exiting immediately from second signal %s", sig)
+			os.Exit(1)
+		}()
+		shutdown()
+		log.Info("Shutdown complete")
 		os.Exit(0)
 	}()
 }
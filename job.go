@@ -1,12 +1,17 @@
 package dockworker
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
 
 // Job is a job
 type Job struct {
 	ID         JobID             `json:"id"`
 	ImageName  string            `json:"image"`
 	Env        map[string]string `json:"env"`
+	Labels     map[string]string `json:"labels"`
 	Status     JobStatus         `json:"status"`
 	Cmds       []Cmd             `json:"cmds"`
 	Message    string            `json:"message"`
@@ -14,15 +19,109 @@ type Job struct {
 	Containers []Container       `json:"containers"`
 	Images     []ImageName       `json:"images"`
 	WebhookURL string            `json:"webhook_url"`
+	Timeout    int               `json:"timeout"`
+	Retry      *RetryPolicy      `json:"retry,omitempty"`
+	Resources  *Resources        `json:"resources,omitempty"`
+	PullPolicy PullPolicy        `json:"pull_policy,omitempty"`
+	Volumes    []VolumeMount     `json:"volumes,omitempty"`
+	Tmpfs      []TmpfsMount      `json:"tmpfs,omitempty"`
+	Caches     []CacheMount      `json:"caches,omitempty"`
+	Inputs     []JobInput        `json:"inputs,omitempty"`
+	Workdir    string            `json:"workdir,omitempty"`
+	User       string            `json:"user,omitempty"`
+	Entrypoint []string          `json:"entrypoint,omitempty"`
+	Artifacts  []string          `json:"artifacts,omitempty"`
+	Attempts   []Attempt         `json:"attempts"`
+	CreateTime time.Time         `json:"create_time"`
 	StartTime  time.Time         `json:"start_time"`
 	EndTime    time.Time         `json:"end_time"`
+	// NetworkMode is bridge, none, host or the
+	// name of a user-defined network
+	NetworkMode string   `json:"network_mode,omitempty"`
+	DNS         []string `json:"dns,omitempty"`
+	ExtraHosts  []string `json:"extra_hosts,omitempty"`
+	// Provenance is what the latest attempt ran
+	// with, set once the image was found
+	Provenance
+	// RegistryCredentials names the credentials the server pulls
+	// the image with, instead of the ones for every job
+	RegistryCredentials string `json:"registry_credentials,omitempty"`
+	// RemovedContainers and RemovedImages list the containers
+	// and images of the job which were cleaned up, so their
+	// IDs no longer refer to anything
+	RemovedContainers []Container `json:"removed_containers,omitempty"`
+	RemovedImages     []ImageName `json:"removed_images,omitempty"`
+	// QueuePosition is the place of a queued job in line
+	// to run, starting at 1. It isn't stored with the job.
+	QueuePosition int `json:"queue_position,omitempty"`
+}
+
+// Attempt is the record of an earlier run of a job which was retried.
+// The Job's own fields always describe the latest attempt.
+type Attempt struct {
+	Status     JobStatus   `json:"status"`
+	Message    string      `json:"message"`
+	Results    []CmdResult `json:"results"`
+	Containers []Container `json:"containers"`
+	Images     []ImageName `json:"images"`
+	StartTime  time.Time   `json:"start_time"`
+	EndTime    time.Time   `json:"end_time"`
+	Provenance
+}
+
+// Provenance records exactly what ran a job
+type Provenance struct {
+	// ImageID and ImageDigest are the base image the commands ran
+	// from, the digest is only set if the image came from a registry
+	ImageID     ImageName `json:"image_id,omitempty"`
+	ImageDigest string    `json:"image_digest,omitempty"`
+	// DockerVersion and Host are the version and
+	// host name of the Docker daemon the job ran on
+	DockerVersion string `json:"docker_version,omitempty"`
+	Host          string `json:"host,omitempty"`
 }
 
 // CmdResult represents the result of running a command
 type CmdResult int
 
-// Cmd is a command to run in the job
-type Cmd []string
+// Cmd is a command to run in the job. In JSON it is either an
+// array of arguments, or an object when it has options set.
+type Cmd struct {
+	Args []string `json:"args"`
+	// Timeout is the number of seconds the command may run for
+	Timeout int `json:"timeout,omitempty"`
+	// Workdir, User and Entrypoint override those of the job
+	Workdir    string   `json:"workdir,omitempty"`
+	User       string   `json:"user,omitempty"`
+	Entrypoint []string `json:"entrypoint,omitempty"`
+}
+
+// cmdObject has the fields of Cmd without its JSON methods
+type cmdObject Cmd
+
+// MarshalJSON encodes the command as an array of
+// arguments unless it has options set
+func (c Cmd) MarshalJSON() ([]byte, error) {
+	if c.Timeout == 0 && c.Workdir == "" && c.User == "" && len(c.Entrypoint) == 0 {
+		return json.Marshal(c.Args)
+	}
+	return json.Marshal(cmdObject(c))
+}
+
+// UnmarshalJSON decodes the command from either
+// an array of arguments or an object
+func (c *Cmd) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		*c = Cmd{}
+		return json.Unmarshal(data, &c.Args)
+	}
+	obj := cmdObject{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*c = Cmd(obj)
+	return nil
+}
 
 // JobStatus represents the status of the Job
 type JobStatus string
@@ -49,4 +148,7 @@ const (
 	JobStatusError JobStatus = "error"
 	// JobStatusStopped state indicates the job was stoped
 	JobStatusStopped JobStatus = "stopped"
+	// JobStatusTimedOut state indicates the job ran for
+	// longer than its timeout and was stopped
+	JobStatusTimedOut JobStatus = "timed_out"
 )
@@ -15,15 +15,24 @@ type JobAPI struct {
 	jobService  JobService
 	logService  LogService
 	stopService StopService
+	eventBus    JobEventBus
+	// artifactService is nil if artifacts aren't kept
+	artifactService ArtifactService
+	// blobStore is nil if jobs can't have inputs
+	blobStore BlobStore
 }
 
 // NewJobAPI creates a new JobAPI
 func NewJobAPI(jobService JobService, logService LogService,
-	stopService StopService) JobAPI {
+	stopService StopService, eventBus JobEventBus, artifactService ArtifactService,
+	blobStore BlobStore) JobAPI {
 	return JobAPI{
-		jobService:  jobService,
-		logService:  logService,
-		stopService: stopService,
+		jobService:      jobService,
+		logService:      logService,
+		stopService:     stopService,
+		eventBus:        eventBus,
+		artifactService: artifactService,
+		blobStore:       blobStore,
 	}
 }
 
@@ -35,6 +44,29 @@ func (api JobAPI) Register(container *restful.Container) {
 		Consumes(restful.MIME_JSON).
 		Produces(restful.MIME_JSON)
 
+	ws.Route(ws.GET("").To(api.listJobs).
+		Operation("listJobs").
+		Param(ws.QueryParameter("status", "only list jobs with this status, may be repeated")).
+		Param(ws.QueryParameter("image", "only list jobs using this image")).
+		Param(ws.QueryParameter("label", "only list jobs with this key=value label, may be repeated")).
+		Param(ws.QueryParameter("created_after", "only list jobs created after this RFC 3339 time")).
+		Param(ws.QueryParameter("created_before", "only list jobs created before this RFC 3339 time")).
+		Param(ws.QueryParameter("started_after", "only list jobs started after this RFC 3339 time")).
+		Param(ws.QueryParameter("started_before", "only list jobs started before this RFC 3339 time")).
+		Param(ws.QueryParameter("ended_after", "only list jobs ended after this RFC 3339 time")).
+		Param(ws.QueryParameter("ended_before", "only list jobs ended before this RFC 3339 time")).
+		Param(ws.QueryParameter("after", "cursor, only list jobs with a greater id").DataType("int")).
+		Param(ws.QueryParameter("limit", "maximum number of jobs to list").DataType("int")).
+		Writes(JobList{}))
+
+	ws.Route(ws.GET("/events").To(api.jobEvents).
+		Operation("jobEvents").
+		Param(ws.QueryParameter("job", "only stream events of the job with this id").DataType("int")).
+		Param(ws.QueryParameter("since", "resume after the event with this sequence number").DataType("int")).
+		Param(ws.HeaderParameter("Last-Event-ID", "resume after the event with this sequence number")).
+		Produces(restful.MIME_JSON, "text/event-stream").
+		Writes(JobEvent{}))
+
 	ws.Route(ws.GET("/{id}").To(api.findJob).
 		Operation("findJob").
 		Param(ws.PathParameter("id", "id of job").DataType("int")).
@@ -42,18 +74,64 @@ func (api JobAPI) Register(container *restful.Container) {
 
 	ws.Route(ws.POST("").To(api.createJob).
 		Operation("createJob").
+		Consumes(restful.MIME_JSON, "multipart/form-data").
 		Reads(Job{}))
 
 	ws.Route(ws.GET("/{id}/logs").To(api.logs).
 		Operation("logs").
 		Param(ws.PathParameter("id", "id of job").DataType("int")).
-		Produces("text/plain"))
+		Param(ws.QueryParameter("follow", "stream the logs until the job is done").DataType("boolean")).
+		Param(ws.QueryParameter("format", "text for the output as it is, or ndjson for a JSON object per line")).
+		Produces("text/plain", MIMENDJSON, restful.MIME_JSON))
+
+	ws.Route(ws.GET("/{id}/cmds/{index}/logs").To(api.cmdLogs).
+		Operation("cmdLogs").
+		Param(ws.PathParameter("id", "id of job").DataType("int")).
+		Param(ws.PathParameter("index", "index of the command, starting at 0").DataType("int")).
+		Param(ws.QueryParameter("format", "text for the output as it is, or ndjson for a JSON object per line")).
+		Produces("text/plain", MIMENDJSON, restful.MIME_JSON))
+
+	ws.Route(ws.GET("/{id}/artifacts").To(api.listArtifacts).
+		Operation("listArtifacts").
+		Param(ws.PathParameter("id", "id of job").DataType("int")).
+		Writes([]ArtifactFile{}))
+
+	ws.Route(ws.GET("/{id}/artifacts/{path:*}").To(api.getArtifact).
+		Operation("getArtifact").
+		Param(ws.PathParameter("id", "id of job").DataType("int")).
+		Param(ws.PathParameter("path", "path of the file in the container")).
+		Produces("application/octet-stream", "application/x-tar", restful.MIME_JSON))
 
 	ws.Route(ws.POST("/{id}/stop").To(api.stopJob).
 		Operation("stopJob").
 		Param(ws.PathParameter("id", "id of job").DataType("int")))
 
 	container.Add(ws)
+
+	queueWS := new(restful.WebService)
+	queueWS.Path("/queue").
+		Produces(restful.MIME_JSON)
+
+	queueWS.Route(queueWS.GET("").To(api.queueStats).
+		Operation("queueStats").
+		Writes(QueueStats{}))
+
+	container.Add(queueWS)
+
+	blobWS := new(restful.WebService)
+	blobWS.Path("/blobs").
+		Produces(restful.MIME_JSON)
+
+	blobWS.Route(blobWS.POST("").To(api.createBlob).
+		Operation("createBlob").
+		Consumes("application/x-tar", "application/gzip", "application/octet-stream").
+		Writes(Blob{}))
+
+	blobWS.Route(blobWS.DELETE("/{id}").To(api.deleteBlob).
+		Operation("deleteBlob").
+		Param(blobWS.PathParameter("id", "id of blob")))
+
+	container.Add(blobWS)
 }
 
 func (api JobAPI) findJob(request *restful.Request, response *restful.Response) {
@@ -78,10 +156,35 @@ func (api JobAPI) findJob(request *restful.Request, response *restful.Response)
 	response.WriteHeaderAndEntity(http.StatusOK, job)
 }
 
+func (api JobAPI) listJobs(request *restful.Request, response *restful.Response) {
+	filter, err := parseJobFilter(request.Request.URL.Query())
+	if err != nil {
+		logAndRespondError(response, http.StatusBadRequest, err)
+		return
+	}
+
+	jobs, err := api.jobService.List(filter)
+	if err != nil {
+		logAndRespondError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, jobs)
+}
+
 func (api JobAPI) createJob(request *restful.Request, response *restful.Response) {
 	job := &Job{}
-	err := request.ReadEntity(job)
-	if err != nil {
+	blobs := []BlobID{}
+	if mr, err := request.Request.MultipartReader(); err == nil {
+		*job, blobs, err = readMultipartJob(api.blobStore, mr)
+		if err != nil {
+			if _, ok := err.(ValidationError); ok {
+				logAndRespondError(response, http.StatusBadRequest, err)
+				return
+			}
+			logAndRespondError(response, http.StatusInternalServerError, err)
+			return
+		}
+	} else if err := request.ReadEntity(job); err != nil {
 		if err == io.EOF {
 			logAndRespondError(response, http.StatusBadRequest, fmt.Errorf("Invalid JSON"))
 			return
@@ -94,8 +197,22 @@ func (api JobAPI) createJob(request *restful.Request, response *restful.Response
 
 	j, err := api.jobService.Add(*job)
 	if err != nil {
-		logAndRespondError(response, http.StatusInternalServerError, err)
-		return
+		// nothing else can use the archives uploaded with the job
+		for _, ID := range blobs {
+			api.blobStore.Delete(ID)
+		}
+		if _, ok := err.(ValidationError); ok {
+			logAndRespondError(response, http.StatusBadRequest, err)
+			return
+		}
+		switch err {
+		case ErrDraining:
+			logAndRespondError(response, http.StatusServiceUnavailable, err)
+			return
+		default:
+			logAndRespondError(response, http.StatusInternalServerError, err)
+			return
+		}
 	}
 	response.WriteHeaderAndEntity(http.StatusCreated, j)
 }
@@ -108,6 +225,57 @@ func (api JobAPI) logs(request *restful.Request, response *restful.Response) {
 	}
 	jobID := JobID(id)
 
+	follow, format, err := logOptions(request)
+	if err != nil {
+		logAndRespondError(response, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := api.jobService.Find(jobID)
+	if err != nil {
+		switch err {
+		case ErrJobNotFound:
+			logAndRespondError(response, http.StatusNotFound, ErrJobNotFound)
+			return
+		default:
+			logAndRespondError(response, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if follow {
+		api.followLogs(jobID, format, response.ResponseWriter)
+		return
+	}
+
+	// get the logs
+	response.ResponseWriter.Header().Set("Content-Type", format.contentType())
+	if err := api.logService.GetLogs(job, format, response.ResponseWriter); err != nil {
+		logAndRespondError(response, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (api JobAPI) cmdLogs(request *restful.Request, response *restful.Response) {
+	id, err := strconv.Atoi(request.PathParameter("id"))
+	if err != nil {
+		logAndRespondError(response, http.StatusInternalServerError, ErrInvalidJobID)
+		return
+	}
+	jobID := JobID(id)
+
+	index, err := strconv.Atoi(request.PathParameter("index"))
+	if err != nil {
+		logAndRespondError(response, http.StatusBadRequest, ErrInvalidCmdIndex)
+		return
+	}
+
+	_, format, err := logOptions(request)
+	if err != nil {
+		logAndRespondError(response, http.StatusBadRequest, err)
+		return
+	}
+
 	job, err := api.jobService.Find(jobID)
 	if err != nil {
 		switch err {
@@ -119,12 +287,171 @@ func (api JobAPI) logs(request *restful.Request, response *restful.Response) {
 			return
 		}
 	}
+	if index < 0 || index >= len(job.Cmds) {
+		logAndRespondError(response, http.StatusNotFound, ErrCmdNotFound)
+		return
+	}
 
 	// get the logs
-	if err := api.logService.GetLogs(job, response.ResponseWriter); err != nil {
+	response.ResponseWriter.Header().Set("Content-Type", format.contentType())
+	if err := api.logService.GetCmdLogs(job, index, format, response.ResponseWriter); err != nil {
+		logAndRespondError(response, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (api JobAPI) listArtifacts(request *restful.Request, response *restful.Response) {
+	job, ok := api.artifactJob(request, response)
+	if !ok {
+		return
+	}
+	files, err := api.artifactService.List(job.ID)
+	if err != nil {
 		logAndRespondError(response, http.StatusInternalServerError, err)
 		return
 	}
+	response.WriteHeaderAndEntity(http.StatusOK, files)
+}
+
+func (api JobAPI) getArtifact(request *restful.Request, response *restful.Response) {
+	job, ok := api.artifactJob(request, response)
+	if !ok {
+		return
+	}
+	file, err := api.artifactService.Find(job.ID, request.PathParameter("path"))
+	if err != nil {
+		switch err {
+		case ErrArtifactNotFound:
+			logAndRespondError(response, http.StatusNotFound, ErrArtifactNotFound)
+			return
+		default:
+			logAndRespondError(response, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if file.Type == ArtifactTypeFile {
+		response.ResponseWriter.Header().Set("Content-Type", "application/octet-stream")
+		response.ResponseWriter.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	} else {
+		response.ResponseWriter.Header().Set("Content-Type", "application/x-tar")
+	}
+	if err := api.artifactService.Write(job.ID, file, response.ResponseWriter); err != nil {
+		// the response has likely started, so all we can do is log it
+		log.Errorf("Error writing artifact %s of job %d: %s", file.Path, job.ID, err)
+	}
+}
+
+func (api JobAPI) createBlob(request *restful.Request, response *restful.Response) {
+	if api.blobStore == nil {
+		logAndRespondError(response, http.StatusBadRequest,
+			validationErrorf("Inputs can't be uploaded, no blob directory is configured"))
+		return
+	}
+	blob, err := createBlob(api.blobStore, request.Request.Body)
+	if err != nil {
+		if _, ok := err.(ValidationError); ok {
+			logAndRespondError(response, http.StatusBadRequest, err)
+			return
+		}
+		logAndRespondError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, blob)
+}
+
+func (api JobAPI) deleteBlob(request *restful.Request, response *restful.Response) {
+	if api.blobStore == nil {
+		logAndRespondError(response, http.StatusNotFound, ErrBlobNotFound)
+		return
+	}
+	err := api.blobStore.Delete(BlobID(request.PathParameter("id")))
+	if err != nil {
+		switch err {
+		case ErrBlobNotFound:
+			logAndRespondError(response, http.StatusNotFound, ErrBlobNotFound)
+			return
+		default:
+			logAndRespondError(response, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// artifactJob finds the job of an artifacts request,
+// responding with an error if it can't
+func (api JobAPI) artifactJob(request *restful.Request, response *restful.Response) (Job, bool) {
+	id, err := strconv.Atoi(request.PathParameter("id"))
+	if err != nil {
+		logAndRespondError(response, http.StatusInternalServerError, ErrInvalidJobID)
+		return Job{}, false
+	}
+	job, err := api.jobService.Find(JobID(id))
+	if err != nil {
+		switch err {
+		case ErrJobNotFound:
+			logAndRespondError(response, http.StatusNotFound, ErrJobNotFound)
+			return Job{}, false
+		default:
+			logAndRespondError(response, http.StatusInternalServerError, err)
+			return Job{}, false
+		}
+	}
+	if api.artifactService == nil {
+		logAndRespondError(response, http.StatusNotFound, ErrArtifactNotFound)
+		return Job{}, false
+	}
+	return job, true
+}
+
+// logOptions reads the follow and format query parameters of a logs request
+func logOptions(request *restful.Request) (bool, LogFormat, error) {
+	follow := false
+	if v := request.QueryParameter("follow"); v != "" {
+		var err error
+		follow, err = strconv.ParseBool(v)
+		if err != nil {
+			return false, "", fmt.Errorf("Invalid follow value %q", v)
+		}
+	}
+	format, err := parseLogFormat(request.QueryParameter("format"))
+	if err != nil {
+		return false, "", err
+	}
+	return follow, format, nil
+}
+
+// followLogs streams the logs, flushing them
+// as soon as they're written by the job
+func (api JobAPI) followLogs(ID JobID, format LogFormat, w http.ResponseWriter) {
+	var closed <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+	w.Header().Set("Content-Type", format.contentType())
+	w.WriteHeader(http.StatusOK)
+	output := io.Writer(w)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+		output = flushWriter{writer: w, flusher: flusher}
+	}
+	// the response has started, so errors can only be logged
+	if err := api.logService.FollowLogs(ID, format, output, closed); err != nil {
+		log.Errorf("Error following logs of job %d: %s", ID, err)
+	}
+}
+
+// flushWriter flushes after every write
+type flushWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.writer.Write(p)
+	fw.flusher.Flush()
+	return n, err
 }
 
 func (api JobAPI) stopJob(request *restful.Request, response *restful.Response) {
@@ -154,6 +481,31 @@ func (api JobAPI) stopJob(request *restful.Request, response *restful.Response)
 	response.WriteHeader(http.StatusAccepted)
 }
 
+// jobEvents streams job events as Server-Sent Events, or
+// over a WebSocket if the client asks to upgrade to one
+func (api JobAPI) jobEvents(request *restful.Request, response *restful.Response) {
+	filter, err := parseJobEventFilter(request.Request, api.eventBus.Epoch(), api.eventBus.LastSeq())
+	if err != nil {
+		logAndRespondError(response, http.StatusBadRequest, err)
+		return
+	}
+
+	if !isWebSocketRequest(request.Request) {
+		streamJobEventsSSE(response.ResponseWriter, api.eventBus, filter)
+		return
+	}
+	ws, err := upgradeWebSocket(response.ResponseWriter, request.Request)
+	if err != nil {
+		log.Infof("Failed WebSocket handshake: %s", err)
+		return
+	}
+	streamJobEventsWebSocket(ws, api.eventBus, filter)
+}
+
+func (api JobAPI) queueStats(request *restful.Request, response *restful.Response) {
+	response.WriteHeaderAndEntity(http.StatusOK, api.jobService.QueueStats())
+}
+
 func logAndRespondError(response *restful.Response, status int, err error) {
 	log.Infof("Error response %d %s", status, err)
 	response.WriteHeaderAndEntity(status, errorResponse(err.Error()))
@@ -27,6 +27,12 @@ func TestAPI(t *testing.T) {
 		assert.Equal(t, 0, len(jobPOST.Images), "Case %d: Should be no images initially", i)
 		assert.Equal(t, webhookServer.URL, jobPOST.WebhookURL, "Case %d: Webhook URLs should match", i)
 
+		// follow the logs while the job runs
+		followed := make(chan string, 1)
+		go func() {
+			followed <- followLogs(t, i, jobURL, jobPOST.ID)
+		}()
+
 		// wait while the job completes
 		waitUntilDone(t, i, jobURL, jobPOST.ID)
 		jobGET := getJob(t, i, jobURL, jobPOST.ID)
@@ -40,6 +46,24 @@ func TestAPI(t *testing.T) {
 		// check the logs of the job
 		logs := getLogs(t, i, jobURL, jobPOST.ID)
 		assert.Equal(t, tc.logs, logs, "Case %d: Logs should match", i)
+		assert.Equal(t, tc.logs, <-followed, "Case %d: Followed logs should match", i)
+
+		// check the structured logs match, command by command
+		lines := getLogLines(t, i, fmt.Sprintf("%s/%d/logs?format=ndjson", jobURL, jobPOST.ID))
+		cmdLogs := ""
+		for c := range jobGET.Containers {
+			for _, line := range getLogLines(t, i, fmt.Sprintf("%s/%d/cmds/%d/logs?format=ndjson", jobURL, jobPOST.ID, c)) {
+				assert.Equal(t, c, line.Cmd, "Case %d: Command index of line should match", i)
+				assert.False(t, line.Time.IsZero(), "Case %d: Line should have a timestamp", i)
+				cmdLogs += line.Line + "\n"
+			}
+		}
+		structuredLogs := ""
+		for _, line := range lines {
+			structuredLogs += line.Line + "\n"
+		}
+		assert.Equal(t, tc.logs, structuredLogs, "Case %d: Structured logs should match", i)
+		assert.Equal(t, tc.logs, cmdLogs, "Case %d: Command logs should match", i)
 
 		// check the webhook results
 		assert.Condition(t, func() bool { return i < len(whRecorder.webhookRequests) }, "Case %d: Webhook requests length not great enough", i)
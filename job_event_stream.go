@@ -0,0 +1,194 @@
+package dockworker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// how often to send something to idle event
+	// streams so proxies don't close them
+	eventStreamKeepAlive = 15 * time.Second
+)
+
+// jobEventFilter decides which events a stream sends
+type jobEventFilter struct {
+	jobID     JobID
+	allJobs   bool
+	lastEpoch string
+	lastSeen  uint64
+}
+
+func (filter jobEventFilter) matches(event JobEvent) bool {
+	return filter.allJobs || event.JobID == filter.jobID || event.Type == JobEventReset
+}
+
+// jobEventID is how an event is identified to clients resuming from it
+func jobEventID(event JobEvent) string {
+	return fmt.Sprintf("%s-%d", event.Epoch, event.Seq)
+}
+
+// parseJobEventFilter reads the job and since query parameters, falling
+// back to the Last-Event-ID header SSE clients send when reconnecting.
+// Without either, the stream starts from the next event.
+func parseJobEventFilter(r *http.Request, epoch string, lastSeq uint64) (jobEventFilter, error) {
+	filter := jobEventFilter{
+		allJobs:   true,
+		lastEpoch: epoch,
+		lastSeen:  lastSeq,
+	}
+	if v := r.URL.Query().Get("job"); v != "" {
+		ID, err := strconv.Atoi(v)
+		if err != nil {
+			return jobEventFilter{}, ErrInvalidJobID
+		}
+		filter.jobID = JobID(ID)
+		filter.allJobs = false
+	}
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		since = r.Header.Get("Last-Event-ID")
+	}
+	if since != "" {
+		sinceEpoch, sinceSeq := epoch, since
+		if i := strings.Index(since, "-"); i >= 0 {
+			sinceEpoch, sinceSeq = since[:i], since[i+1:]
+		}
+		seq, err := strconv.ParseUint(sinceSeq, 10, 64)
+		if err != nil {
+			return jobEventFilter{}, fmt.Errorf("Invalid event ID %q", since)
+		}
+		if sinceEpoch == epoch && seq > lastSeq {
+			// a bare sequence number past the last one
+			// must be from before the server restarted
+			sinceEpoch = ""
+		}
+		filter.lastEpoch = sinceEpoch
+		filter.lastSeen = seq
+	}
+	return filter, nil
+}
+
+// streamJobEventsSSE sends the events as Server-Sent Events
+// until the client goes away or falls behind
+func streamJobEventsSSE(w http.ResponseWriter, eventBus JobEventBus, filter jobEventFilter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	var closed <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+
+	backlog, events := eventBus.Subscribe(filter.lastEpoch, filter.lastSeen)
+	defer eventBus.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, event := range backlog {
+		if err := writeSSEEvent(w, event, filter); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// the client can resume from the last event it got
+				return
+			}
+			if err := writeSSEEvent(w, event, filter); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSEEvent(w http.ResponseWriter, event JobEvent, filter jobEventFilter) error {
+	if !filter.matches(event) {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Failed to marshal job event %d: %s", event.Seq, err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", jobEventID(event), event.Type, data)
+	return err
+}
+
+// streamJobEventsWebSocket sends the events as WebSocket text
+// messages until the client goes away or falls behind
+func streamJobEventsWebSocket(ws *wsConn, eventBus JobEventBus, filter jobEventFilter) {
+	backlog, events := eventBus.Subscribe(filter.lastEpoch, filter.lastSeen)
+	defer eventBus.Unsubscribe(events)
+
+	done := make(chan bool)
+	go func() {
+		ws.ReadLoop()
+		close(done)
+	}()
+
+	for _, event := range backlog {
+		if err := writeWebSocketEvent(ws, event, filter); err != nil {
+			ws.Close(wsCloseNormal, "")
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				ws.Close(wsCloseTryAgainLater, "Fell behind, resume from the last event")
+				return
+			}
+			if err := writeWebSocketEvent(ws, event, filter); err != nil {
+				ws.Close(wsCloseNormal, "")
+				return
+			}
+		case <-keepAlive.C:
+			if err := ws.Ping(); err != nil {
+				ws.Close(wsCloseNormal, "")
+				return
+			}
+		case <-done:
+			ws.Close(wsCloseNormal, "")
+			return
+		}
+	}
+}
+
+func writeWebSocketEvent(ws *wsConn, event JobEvent, filter jobEventFilter) error {
+	if !filter.matches(event) {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Failed to marshal job event %d: %s", event.Seq, err)
+		return nil
+	}
+	return ws.WriteText(data)
+}
@@ -0,0 +1,174 @@
+package dockworker
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// how many events a subscriber can fall behind by
+	// before it's dropped and has to resume
+	subscriberBufferSize = 256
+)
+
+// JobEventType is the kind of change a JobEvent describes
+type JobEventType string
+
+const (
+	// JobEventStatus indicates the status of the job changed
+	JobEventStatus JobEventType = "status"
+	// JobEventMessage indicates the message of the job changed
+	JobEventMessage JobEventType = "message"
+	// JobEventStartTime indicates the job started running
+	JobEventStartTime JobEventType = "start_time"
+	// JobEventEndTime indicates the job finished running
+	JobEventEndTime JobEventType = "end_time"
+	// JobEventContainer indicates a container was created for a command
+	JobEventContainer JobEventType = "container"
+	// JobEventCmdResult indicates a command finished
+	JobEventCmdResult JobEventType = "cmd_result"
+	// JobEventImage indicates an image was committed after a command
+	JobEventImage JobEventType = "image"
+	// JobEventContainerRemoved indicates a container of the job was cleaned up
+	JobEventContainerRemoved JobEventType = "container_removed"
+	// JobEventImageRemoved indicates an image of the job was cleaned up
+	JobEventImageRemoved JobEventType = "image_removed"
+	// JobEventRequeued indicates the job was put
+	// back in the queue to start over
+	JobEventRequeued JobEventType = "requeued"
+	// JobEventImageResolved indicates the image the job
+	// runs was found, with its digest if it has one
+	JobEventImageResolved JobEventType = "image_resolved"
+	// JobEventAttempt indicates a new attempt of the job is starting
+	JobEventAttempt JobEventType = "attempt"
+	// JobEventReset indicates the events a client asked to resume
+	// from are gone, so it has to fetch the jobs again
+	JobEventReset JobEventType = "reset"
+)
+
+// JobEvent describes a change made to a job. Seq increases by one
+// with every event, so clients can resume from the last one they saw.
+// Sequence numbers start over when the server restarts, which changes
+// the Epoch, so they're only comparable between events of one epoch.
+type JobEvent struct {
+	Epoch string       `json:"epoch"`
+	Seq   uint64       `json:"seq"`
+	Type  JobEventType `json:"type"`
+	JobID JobID        `json:"job_id"`
+	Time  time.Time    `json:"time"`
+	// Status is the status of the job after the change
+	Status    JobStatus  `json:"status"`
+	Message   string     `json:"message,omitempty"`
+	Container Container  `json:"container,omitempty"`
+	Result    *CmdResult `json:"result,omitempty"`
+	Image     ImageName  `json:"image,omitempty"`
+	Digest    string     `json:"digest,omitempty"`
+	StartTime *time.Time `json:"start_time,omitempty"`
+	EndTime   *time.Time `json:"end_time,omitempty"`
+}
+
+// JobEventBus hands out job events to subscribers, keeping
+// a history of recent events for subscribers to resume from
+type JobEventBus interface {
+	Publish(event JobEvent) JobEvent
+	// Epoch is the epoch of the events published since the server started
+	Epoch() string
+	LastSeq() uint64
+	// Subscribe returns the events in the history after since, and a
+	// channel of the events after those. The channel is closed if the
+	// subscriber falls too far behind. If since is from another epoch
+	// the events after it are gone, so there's a reset event instead.
+	Subscribe(epoch string, since uint64) ([]JobEvent, chan JobEvent)
+	Unsubscribe(events chan JobEvent)
+}
+
+// NewJobEventBus returns a new JobEventBus which
+// keeps up to history events to resume from
+func NewJobEventBus(history int) JobEventBus {
+	return &jobEventBus{
+		lock:        &sync.Mutex{},
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		maxHistory:  history,
+		subscribers: make(map[chan JobEvent]bool),
+	}
+}
+
+type jobEventBus struct {
+	lock        *sync.Mutex
+	epoch       string
+	lastSeq     uint64
+	history     []JobEvent
+	maxHistory  int
+	subscribers map[chan JobEvent]bool
+}
+
+func (bus *jobEventBus) Publish(event JobEvent) JobEvent {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	bus.lastSeq++
+	event.Epoch = bus.epoch
+	event.Seq = bus.lastSeq
+	if bus.maxHistory > 0 {
+		bus.history = append(bus.history, event)
+		if len(bus.history) > bus.maxHistory {
+			bus.history = bus.history[len(bus.history)-bus.maxHistory:]
+		}
+	}
+	for events := range bus.subscribers {
+		select {
+		case events <- event:
+		default:
+			log.Warnf("Dropping job event subscriber which fell behind at event %d", event.Seq)
+			delete(bus.subscribers, events)
+			close(events)
+		}
+	}
+	return event
+}
+
+func (bus *jobEventBus) Epoch() string {
+	return bus.epoch
+}
+
+func (bus *jobEventBus) LastSeq() uint64 {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	return bus.lastSeq
+}
+
+func (bus *jobEventBus) Subscribe(epoch string, since uint64) ([]JobEvent, chan JobEvent) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	backlog := []JobEvent{}
+	if epoch != bus.epoch {
+		// the server restarted since, so the subscriber can't tell
+		// which of our events it has seen. It starts over from now.
+		backlog = append(backlog, JobEvent{
+			Epoch: bus.epoch,
+			Seq:   bus.lastSeq,
+			Type:  JobEventReset,
+			Time:  time.Now(),
+		})
+	} else {
+		for _, event := range bus.history {
+			if event.Seq > since {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+	events := make(chan JobEvent, subscriberBufferSize)
+	bus.subscribers[events] = true
+	return backlog, events
+}
+
+func (bus *jobEventBus) Unsubscribe(events chan JobEvent) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	if bus.subscribers[events] {
+		delete(bus.subscribers, events)
+		close(events)
+	}
+}
@@ -0,0 +1,227 @@
+package dockworker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobEventBusResume(t *testing.T) {
+	bus := NewJobEventBus(3)
+	for i := 0; i < 5; i++ {
+		bus.Publish(JobEvent{JobID: JobID(i)})
+	}
+	assert.Equal(t, uint64(5), bus.LastSeq())
+
+	backlog, events := bus.Subscribe(bus.Epoch(), 3)
+	assert.Equal(t, []uint64{4, 5}, eventSeqs(backlog), "Backlog should have the events after since")
+	bus.Publish(JobEvent{JobID: 5})
+	assert.Equal(t, uint64(6), (<-events).Seq, "New events should be sent to subscribers")
+	bus.Unsubscribe(events)
+	_, ok := <-events
+	assert.False(t, ok, "Unsubscribing should close the channel")
+
+	backlog, events = bus.Subscribe(bus.Epoch(), 0)
+	assert.Equal(t, []uint64{4, 5, 6}, eventSeqs(backlog), "Backlog should only go back as far as the history")
+	bus.Unsubscribe(events)
+
+	backlog, events = bus.Subscribe("before-restart", 5)
+	if assert.Equal(t, 1, len(backlog)) {
+		assert.Equal(t, JobEventReset, backlog[0].Type, "Resuming from another epoch should reset the subscriber")
+		assert.Equal(t, bus.Epoch(), backlog[0].Epoch)
+		assert.Equal(t, uint64(6), backlog[0].Seq, "The reset should carry on from the last event")
+	}
+	bus.Publish(JobEvent{JobID: 6})
+	event := <-events
+	assert.Equal(t, uint64(7), event.Seq)
+	assert.Equal(t, bus.Epoch(), event.Epoch)
+	bus.Unsubscribe(events)
+}
+
+func TestJobEventBusDropsSlowSubscribers(t *testing.T) {
+	bus := NewJobEventBus(0)
+	_, events := bus.Subscribe(bus.Epoch(), 0)
+	for i := 0; i <= subscriberBufferSize; i++ {
+		bus.Publish(JobEvent{})
+	}
+	received := 0
+	for range events {
+		received++
+	}
+	assert.Equal(t, subscriberBufferSize, received, "Subscriber should get the buffered events before being dropped")
+	// unsubscribing after being dropped shouldn't panic
+	bus.Unsubscribe(events)
+}
+
+func TestJobUpdaterPublishesEvents(t *testing.T) {
+	store := NewJobStore()
+	job, _ := store.Add(Job{Status: JobStatusQueued})
+	bus := NewJobEventBus(10)
+	updater := NewJobUpdater(store, bus)
+
+	updater.UpdateStatus(&job, JobStatusRunning)
+	updater.AddContainer(&job, "abc")
+	updater.AddCmdResult(&job, 1)
+
+	backlog, events := bus.Subscribe(bus.Epoch(), 0)
+	bus.Unsubscribe(events)
+	assert.Equal(t, 3, len(backlog))
+	assert.Equal(t, JobEventStatus, backlog[0].Type)
+	assert.Equal(t, JobStatusRunning, backlog[0].Status)
+	assert.Equal(t, Container("abc"), backlog[1].Container)
+	assert.Equal(t, CmdResult(1), *backlog[2].Result)
+	for _, event := range backlog {
+		assert.Equal(t, job.ID, event.JobID)
+	}
+}
+
+func TestJobEventsSSE(t *testing.T) {
+	bus, server := eventsTestServer()
+	defer server.Close()
+	bus.Publish(JobEvent{Type: JobEventStatus, JobID: 1})
+	bus.Publish(JobEvent{Type: JobEventStatus, JobID: 2})
+	bus.Publish(JobEvent{Type: JobEventStatus, JobID: 1})
+
+	req, _ := http.NewRequest("GET", server.URL+"/jobs/events?job=1", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	assert.Equal(t, uint64(3), readSSEEvent(t, reader).Seq, "Stream should resume after the last event ID")
+	bus.Publish(JobEvent{Type: JobEventStatus, JobID: 2})
+	bus.Publish(JobEvent{Type: JobEventEndTime, JobID: 1})
+	event := readSSEEvent(t, reader)
+	assert.Equal(t, uint64(5), event.Seq, "Events of other jobs should be filtered out")
+	assert.Equal(t, JobEventEndTime, event.Type)
+
+	resp, err = http.Get(server.URL + "/jobs/events?since=abc")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Invalid since should be rejected")
+
+	for _, since := range []string{bus.Epoch() + "-3", "3"} {
+		resp, err = http.Get(server.URL + "/jobs/events?job=1&since=" + since)
+		assert.NoError(t, err)
+		reader = bufio.NewReader(resp.Body)
+		event = readSSEEvent(t, reader)
+		assert.Equal(t, uint64(5), event.Seq, "Stream should resume after %s", since)
+		resp.Body.Close()
+	}
+
+	for _, since := range []string{"oldepoch-3", "100"} {
+		resp, err = http.Get(server.URL + "/jobs/events?job=1&since=" + since)
+		assert.NoError(t, err)
+		reader = bufio.NewReader(resp.Body)
+		line, _ := reader.ReadString('\n')
+		assert.Equal(t, fmt.Sprintf("id: %s-5\n", bus.Epoch()), line, "The event ID should hold the epoch")
+		event = readSSEEvent(t, reader)
+		assert.Equal(t, JobEventReset, event.Type, "Stream should reset after a restart, from %s", since)
+		resp.Body.Close()
+	}
+}
+
+func TestJobEventsWebSocket(t *testing.T) {
+	bus, server := eventsTestServer()
+	defer server.Close()
+	bus.Publish(JobEvent{Type: JobEventStatus, JobID: 1})
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/jobs/events?since=0"
+	dialer := websocket.Dialer{WriteBufferSize: 1024}
+	conn, resp, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to open WebSocket: %s", err)
+	}
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	event := JobEvent{}
+	messageType, payload, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, websocket.TextMessage, messageType)
+	assert.NoError(t, json.Unmarshal(payload, &event))
+	assert.Equal(t, uint64(1), event.Seq, "Backlog should be sent first")
+
+	bus.Publish(JobEvent{Type: JobEventStatus, JobID: 1})
+	_, payload, err = conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(payload, &event))
+	assert.Equal(t, uint64(2), event.Seq, "New events should be sent")
+
+	// bigger than the write buffer, so it's sent in fragments
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 10000))))
+	pong := make(chan string, 1)
+	conn.SetPongHandler(func(data string) error {
+		pong <- data
+		return nil
+	})
+	assert.NoError(t, conn.WriteControl(websocket.PingMessage, []byte("hi"), time.Now().Add(time.Second)))
+	go conn.ReadMessage()
+	select {
+	case data := <-pong:
+		assert.Equal(t, "hi", data, "Pings should be answered after a fragmented message")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ping wasn't answered")
+	}
+}
+
+func TestJobEventsWebSocketOrigin(t *testing.T) {
+	_, server := eventsTestServer()
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/jobs/events"
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": []string{"http://evil.example.com"}})
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Pages from other sites shouldn't get the events")
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": []string{server.URL}})
+	if assert.NoError(t, err, "Pages served by us should get the events") {
+		conn.Close()
+	}
+}
+
+func eventsTestServer() (JobEventBus, *httptest.Server) {
+	bus := NewJobEventBus(10)
+	container := restful.NewContainer()
+	NewJobAPI(nil, nil, nil, bus, nil, nil).Register(container)
+	return bus, httptest.NewServer(container)
+}
+
+func eventSeqs(events []JobEvent) []uint64 {
+	seqs := []uint64{}
+	for _, event := range events {
+		seqs = append(seqs, event.Seq)
+	}
+	return seqs
+}
+
+func readSSEEvent(t *testing.T, reader *bufio.Reader) JobEvent {
+	event := JobEvent{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading event stream: %s", err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+		}
+		if line == "\n" && event.Seq != 0 {
+			return event
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package dockworker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	jobLogFileName = "jobs.log"
+
+	// the job log is compacted once it holds this many times more
+	// records than there are jobs, and at least minCompactRecords
+	compactRatio      = 4
+	minCompactRecords = 1000
+)
+
+// NewFileJobStore creates a JobStore which persists jobs to an
+// append-only log in the given directory. Any jobs already in
+// the log are loaded, so jobs survive restarts of the server.
+func NewFileJobStore(dir string) (JobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create data directory %s: %s", dir, err)
+	}
+	store := &fileJobStore{
+		lock:              &sync.RWMutex{},
+		path:              filepath.Join(dir, jobLogFileName),
+		nextID:            0,
+		data:              make(map[JobID]Job),
+		minCompactRecords: minCompactRecords,
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// fileJobRecord is a single entry in the job log.
+// Later entries for a job replace earlier ones.
+type fileJobRecord struct {
+	NextID JobID `json:"next_id"`
+	Job    *Job  `json:"job,omitempty"`
+}
+
+type fileJobStore struct {
+	lock   *sync.RWMutex
+	path   string
+	file   *os.File
+	nextID JobID
+	data   map[JobID]Job
+	// records is the number of records in the job log
+	records           int
+	minCompactRecords int
+}
+
+func (store *fileJobStore) Add(j Job) (Job, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	j.ID = store.nextID
+	if err := store.append(fileJobRecord{NextID: j.ID + 1, Job: &j}); err != nil {
+		return Job{}, err
+	}
+	store.data[j.ID] = j
+	store.nextID = j.ID + 1
+	store.compactIfLarge()
+	return j, nil
+}
+
+func (store *fileJobStore) Find(ID JobID) (Job, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	j, ok := store.data[ID]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return j, nil
+}
+
+func (store *fileJobStore) Update(job Job) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.data[job.ID]; !ok {
+		return ErrJobNotFound
+	}
+	if err := store.append(fileJobRecord{NextID: store.nextID, Job: &job}); err != nil {
+		return err
+	}
+	store.data[job.ID] = job
+	store.compactIfLarge()
+	return nil
+}
+
+func (store *fileJobStore) List(filter JobFilter) ([]Job, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return filterJobs(store.data, filter), nil
+}
+
+// load replays the job log into memory
+func (store *fileJobStore) load() error {
+	f, err := os.Open(store.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to open job log %s: %s", store.path, err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("Failed to read job log %s: %s", store.path, err)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err == io.EOF {
+				return nil
+			}
+			continue
+		}
+		record := fileJobRecord{}
+		if jsonErr := json.Unmarshal(line, &record); jsonErr != nil {
+			if err == io.EOF {
+				// every record ends with a newline, so one without
+				// means we stopped mid-write and nothing came after
+				log.Warnf("Ignoring partially written record at end of job log %s: %s", store.path, jsonErr)
+				return nil
+			}
+			// anything after a corrupt record would be lost
+			// once the log is compacted, so refuse to go on
+			return fmt.Errorf("Job log %s is corrupt at line %d: %s", store.path, lineNum, jsonErr)
+		}
+		if record.NextID > store.nextID {
+			store.nextID = record.NextID
+		}
+		if record.Job != nil {
+			store.data[record.Job.ID] = *record.Job
+			if record.Job.ID >= store.nextID {
+				store.nextID = record.Job.ID + 1
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// compact rewrites the job log so it only holds the latest
+// entry for each job, then opens it for appending. It must
+// be called with the lock held for writing.
+func (store *fileJobStore) compact() error {
+	tmpPath := store.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to create job log %s: %s", tmpPath, err)
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, job := range store.data {
+		j := job
+		if err := encoder.Encode(fileJobRecord{NextID: store.nextID, Job: &j}); err != nil {
+			f.Close()
+			return fmt.Errorf("Failed to write job log %s: %s", tmpPath, err)
+		}
+	}
+	// always record the next ID, even when there are no jobs
+	if err := encoder.Encode(fileJobRecord{NextID: store.nextID}); err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to write job log %s: %s", tmpPath, err)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to write job log %s: %s", tmpPath, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to sync job log %s: %s", tmpPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Failed to close job log %s: %s", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, store.path); err != nil {
+		return fmt.Errorf("Failed to replace job log %s: %s", store.path, err)
+	}
+
+	file, err := os.OpenFile(store.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to open job log %s: %s", store.path, err)
+	}
+	if store.file != nil {
+		store.file.Close()
+	}
+	store.file = file
+	store.records = len(store.data) + 1
+	return nil
+}
+
+// append durably writes a record to the end of the job log
+func (store *fileJobStore) append(record fileJobRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("Failed to marshal job log record: %s", err)
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := store.file.Write(line); err != nil {
+		log.Errorf("Failed to write to job log %s: %s", store.path, err)
+		return err
+	}
+	if err := store.file.Sync(); err != nil {
+		log.Errorf("Failed to sync job log %s: %s", store.path, err)
+		return err
+	}
+	store.records++
+	return nil
+}
+
+// compactIfLarge compacts the job log once it's mostly replaced
+// records, it must be called with the lock held for writing
+func (store *fileJobStore) compactIfLarge() {
+	if store.records < store.minCompactRecords || store.records <= compactRatio*(len(store.data)+1) {
+		return
+	}
+	// the records are already written, so carry on
+	// appending to the old log if this fails
+	if err := store.compact(); err != nil {
+		log.Errorf("Failed to compact job log %s: %s", store.path, err)
+	}
+}
@@ -0,0 +1,199 @@
+package dockworker
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultListLimit is the number of jobs listed when no limit is given
+	DefaultListLimit = 100
+	// MaxListLimit is the most jobs which can be listed at once
+	MaxListLimit = 1000
+)
+
+// JobFilter selects which jobs are listed. Zero values
+// don't filter on that field.
+type JobFilter struct {
+	Statuses      []JobStatus
+	ImageName     string
+	Labels        map[string]string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	StartedAfter  time.Time
+	StartedBefore time.Time
+	EndedAfter    time.Time
+	EndedBefore   time.Time
+	// After is a cursor, only jobs with a greater ID are listed
+	After *JobID
+	// Limit is the maximum number of jobs to list
+	Limit int
+}
+
+// JobList is a page of listed jobs
+type JobList struct {
+	Jobs []Job `json:"jobs"`
+	// Next is the cursor for the next page, it is
+	// only present if there are more jobs to list
+	Next *JobID `json:"next,omitempty"`
+}
+
+// Matches returns whether the job passes the filter,
+// ignoring the pagination fields
+func (f JobFilter) Matches(job Job) bool {
+	if len(f.Statuses) > 0 && !containsStatus(f.Statuses, job.Status) {
+		return false
+	}
+	if f.ImageName != "" && f.ImageName != job.ImageName {
+		return false
+	}
+	for k, v := range f.Labels {
+		if label, ok := job.Labels[k]; !ok || label != v {
+			return false
+		}
+	}
+	return inTimeRange(job.CreateTime, f.CreatedAfter, f.CreatedBefore) &&
+		inTimeRange(job.StartTime, f.StartedAfter, f.StartedBefore) &&
+		inTimeRange(job.EndTime, f.EndedAfter, f.EndedBefore)
+}
+
+// QueryValues encodes the filter as the query parameters of GET /jobs
+func (f JobFilter) QueryValues() url.Values {
+	values := url.Values{}
+	for _, status := range f.Statuses {
+		values.Add("status", string(status))
+	}
+	if f.ImageName != "" {
+		values.Set("image", f.ImageName)
+	}
+	for k, v := range f.Labels {
+		values.Add("label", fmt.Sprintf("%s=%s", k, v))
+	}
+	setTime(values, "created_after", f.CreatedAfter)
+	setTime(values, "created_before", f.CreatedBefore)
+	setTime(values, "started_after", f.StartedAfter)
+	setTime(values, "started_before", f.StartedBefore)
+	setTime(values, "ended_after", f.EndedAfter)
+	setTime(values, "ended_before", f.EndedBefore)
+	if f.After != nil {
+		values.Set("after", strconv.Itoa(int(*f.After)))
+	}
+	if f.Limit != 0 {
+		values.Set("limit", strconv.Itoa(f.Limit))
+	}
+	return values
+}
+
+// parseJobFilter decodes the query parameters of GET /jobs
+func parseJobFilter(values url.Values) (JobFilter, error) {
+	f := JobFilter{
+		ImageName: values.Get("image"),
+		Limit:     DefaultListLimit,
+	}
+	for _, status := range values["status"] {
+		for _, s := range strings.Split(status, ",") {
+			f.Statuses = append(f.Statuses, JobStatus(s))
+		}
+	}
+	for _, label := range values["label"] {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 {
+			return JobFilter{}, fmt.Errorf("Invalid label filter %q, expected key=value", label)
+		}
+		if f.Labels == nil {
+			f.Labels = make(map[string]string)
+		}
+		f.Labels[parts[0]] = parts[1]
+	}
+
+	times := []struct {
+		name string
+		dest *time.Time
+	}{
+		{"created_after", &f.CreatedAfter},
+		{"created_before", &f.CreatedBefore},
+		{"started_after", &f.StartedAfter},
+		{"started_before", &f.StartedBefore},
+		{"ended_after", &f.EndedAfter},
+		{"ended_before", &f.EndedBefore},
+	}
+	for _, t := range times {
+		v := values.Get(t.name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return JobFilter{}, fmt.Errorf("Invalid time for %s, expected RFC 3339: %s", t.name, v)
+		}
+		*t.dest = parsed
+	}
+
+	if v := values.Get("after"); v != "" {
+		after, err := strconv.Atoi(v)
+		if err != nil {
+			return JobFilter{}, fmt.Errorf("Invalid cursor %q", v)
+		}
+		ID := JobID(after)
+		f.After = &ID
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > MaxListLimit {
+			return JobFilter{}, fmt.Errorf("Invalid limit %q, must be between 1 and %d", v, MaxListLimit)
+		}
+		f.Limit = limit
+	}
+	return f, nil
+}
+
+// filterJobs returns the jobs which pass the filter in order of ID
+func filterJobs(data map[JobID]Job, f JobFilter) []Job {
+	var IDs []int
+	for ID, job := range data {
+		if f.After != nil && ID <= *f.After {
+			continue
+		}
+		if f.Matches(job) {
+			IDs = append(IDs, int(ID))
+		}
+	}
+	sort.Ints(IDs)
+	if f.Limit > 0 && len(IDs) > f.Limit {
+		IDs = IDs[:f.Limit]
+	}
+	jobs := make([]Job, 0, len(IDs))
+	for _, ID := range IDs {
+		jobs = append(jobs, data[JobID(ID)])
+	}
+	return jobs
+}
+
+func containsStatus(statuses []JobStatus, status JobStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func inTimeRange(t, after, before time.Time) bool {
+	if !after.IsZero() && !t.After(after) {
+		return false
+	}
+	if !before.IsZero() && !t.Before(before) {
+		return false
+	}
+	return true
+}
+
+func setTime(values url.Values, name string, t time.Time) {
+	if !t.IsZero() {
+		values.Set(name, t.Format(time.RFC3339Nano))
+	}
+}
@@ -0,0 +1,65 @@
+package dockworker
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobFilterQueryValues(t *testing.T) {
+	after := JobID(7)
+	filter := JobFilter{
+		Statuses:     []JobStatus{JobStatusQueued, JobStatusRunning},
+		ImageName:    "ubuntu:14.04",
+		Labels:       map[string]string{"project": "dockworker"},
+		CreatedAfter: time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC),
+		EndedBefore:  time.Date(2016, 6, 2, 12, 0, 0, 0, time.UTC),
+		After:        &after,
+		Limit:        10,
+	}
+
+	parsed, err := parseJobFilter(filter.QueryValues())
+	assert.NoError(t, err)
+	assert.Equal(t, filter, parsed, "Filter should survive encoding as query parameters")
+
+	parsed, err = parseJobFilter(JobFilter{}.QueryValues())
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultListLimit, parsed.Limit, "Limit should default when not given")
+}
+
+func TestParseJobFilterErrors(t *testing.T) {
+	invalid := []string{
+		"label=novalue",
+		"created_after=yesterday",
+		"after=abc",
+		"limit=0",
+		"limit=100000",
+	}
+	for i, query := range invalid {
+		values, _ := url.ParseQuery(query)
+		_, err := parseJobFilter(values)
+		assert.Error(t, err, "Case %d: %s should be rejected", i, query)
+	}
+}
+
+func TestJobServiceListPages(t *testing.T) {
+	store := NewJobStore()
+	for i := 0; i < 5; i++ {
+		store.Add(Job{})
+	}
+	service := NewJobService(store, nil, nil, nil, NewRegistryAuth("", ""), Config{})
+
+	list, err := service.List(JobFilter{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []JobID{0, 1}, jobIDs(list.Jobs), "First page should match")
+	assert.Equal(t, JobID(1), *list.Next, "Next cursor should be the last listed job")
+
+	list, _ = service.List(JobFilter{After: list.Next, Limit: 2})
+	assert.Equal(t, []JobID{2, 3}, jobIDs(list.Jobs), "Second page should match")
+
+	list, _ = service.List(JobFilter{After: list.Next, Limit: 2})
+	assert.Equal(t, []JobID{4}, jobIDs(list.Jobs), "Last page should match")
+	assert.Nil(t, list.Next, "Last page should have no next cursor")
+}
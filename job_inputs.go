@@ -0,0 +1,216 @@
+package dockworker
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// multipartJobField is the part of a multipart job request holding the job
+	multipartJobField = "job"
+	// multipartInputField is the name of the parts of a
+	// multipart job request holding input archives
+	multipartInputField = "input"
+)
+
+// JobInput is a tar archive extracted into the
+// first container of the job before it starts
+type JobInput struct {
+	// Path is the absolute path the archive is extracted to
+	Path string `json:"path"`
+	Blob BlobID `json:"blob"`
+}
+
+// openArchive returns a reader of the tar archive, which may be gzipped
+func openArchive(r io.Reader) (*tar.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return tar.NewReader(gr), nil
+	}
+	return tar.NewReader(br), nil
+}
+
+// checkArchive returns a ValidationError unless the reader holds a whole tar archive
+func checkArchive(r io.Reader) error {
+	tr, err := openArchive(r)
+	if err != nil {
+		return validationErrorf("Input is not a tar archive: %s", err)
+	}
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return validationErrorf("Input is not a tar archive: %s", err)
+		}
+	}
+}
+
+// createBlob stores the archive as a new blob,
+// returning a ValidationError if it isn't one
+func createBlob(blobStore BlobStore, r io.Reader) (Blob, error) {
+	blob, err := blobStore.Create(r)
+	if err != nil {
+		return Blob{}, err
+	}
+	stored, err := blobStore.Open(blob.ID)
+	if err != nil {
+		blobStore.Delete(blob.ID)
+		return Blob{}, err
+	}
+	err = checkArchive(stored)
+	stored.Close()
+	if err != nil {
+		blobStore.Delete(blob.ID)
+		return Blob{}, err
+	}
+	return blob, nil
+}
+
+// relocateArchive copies the archive to the writer as an uncompressed
+// archive with every file moved under dir, so it can be extracted at
+// the root of a container. Docker creates any directories in dir which
+// don't exist yet, where extracting at dir itself would need them to.
+func relocateArchive(r io.Reader, dir string, w io.Writer) error {
+	tr, err := openArchive(r)
+	if err != nil {
+		return err
+	}
+	dir = strings.TrimPrefix(path.Clean(dir), "/")
+	tw := tar.NewWriter(w)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		isDir := header.Typeflag == tar.TypeDir
+		header.Name = path.Join(dir, header.Name)
+		if isDir {
+			header.Name += "/"
+		}
+		if header.Typeflag == tar.TypeLink {
+			// hard links are named relative to the archive too
+			header.Linkname = path.Join(dir, header.Linkname)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// uploadInputs extracts the inputs of the job into the container
+func uploadInputs(client Runtime, blobStore BlobStore, job Job, container string) error {
+	for _, input := range job.Inputs {
+		log.Debugf("Uploading input %s of job %d to %s", input.Blob, job.ID, input.Path)
+		if err := uploadInput(client, blobStore, input, container); err != nil {
+			return fmt.Errorf("Failed to upload input %s to %s: %s", input.Blob, input.Path, err)
+		}
+	}
+	return nil
+}
+
+func uploadInput(client Runtime, blobStore BlobStore, input JobInput, container string) error {
+	blob, err := blobStore.Open(input.Blob)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(relocateArchive(blob, input.Path, w))
+	}()
+	err = client.UploadToContainer(container, docker.UploadToContainerOptions{
+		InputStream: r,
+		Path:        "/",
+	})
+	// unblock the copy if the upload gave up early
+	r.Close()
+	return err
+}
+
+// readMultipartJob reads a job and its input archives from a multipart
+// body. Each input part is stored as a blob and fills in the next input
+// of the job without a blob, in order. It returns the blobs it stored.
+func readMultipartJob(blobStore BlobStore, mr *multipart.Reader) (Job, []BlobID, error) {
+	job := Job{}
+	blobs := []BlobID{}
+	fail := func(err error) (Job, []BlobID, error) {
+		for _, ID := range blobs {
+			blobStore.Delete(ID)
+		}
+		return Job{}, nil, err
+	}
+
+	hasJob := false
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(validationErrorf("Invalid multipart body: %s", err))
+		}
+		switch part.FormName() {
+		case multipartJobField:
+			if err := json.NewDecoder(part).Decode(&job); err != nil {
+				return fail(validationErrorf("Invalid JSON"))
+			}
+			hasJob = true
+		case multipartInputField:
+			if blobStore == nil {
+				return fail(validationErrorf("Inputs can't be uploaded, no blob directory is configured"))
+			}
+			blob, err := createBlob(blobStore, part)
+			if err != nil {
+				return fail(err)
+			}
+			blobs = append(blobs, blob.ID)
+		default:
+			return fail(validationErrorf("Unexpected part %q", part.FormName()))
+		}
+		part.Close()
+	}
+	if !hasJob {
+		return fail(validationErrorf("Missing %q part", multipartJobField))
+	}
+
+	next := 0
+	for i := range job.Inputs {
+		if job.Inputs[i].Blob != "" {
+			continue
+		}
+		if next == len(blobs) {
+			return fail(validationErrorf("Input %d has no blob and no archive was uploaded for it", i))
+		}
+		job.Inputs[i].Blob = blobs[next]
+		next++
+	}
+	if next < len(blobs) {
+		return fail(validationErrorf("Uploaded %d archives but only %d inputs need one", len(blobs), next))
+	}
+	return job, blobs, nil
+}
@@ -0,0 +1,145 @@
+package dockworker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testArchive returns a tar archive of the files, where names
+// ending in a slash are directories, in the order given
+func testArchive(t *testing.T, files ...string) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, name := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(name))}
+		if strings.HasSuffix(name, "/") {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+			header.Size = 0
+		}
+		assert.NoError(t, tw.WriteHeader(header))
+		if header.Size > 0 {
+			tw.Write([]byte(name))
+		}
+	}
+	assert.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func archiveNames(t *testing.T, data []byte) []string {
+	tr, err := openArchive(bytes.NewReader(data))
+	assert.NoError(t, err)
+	names := []string{}
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name)
+	}
+	return names
+}
+
+func TestFileBlobStore(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+	store, err := NewFileBlobStore(dir)
+	assert.NoError(t, err)
+
+	blob, err := store.Create(strings.NewReader("contents"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), blob.Size)
+	ok, err := store.Exists(blob.ID)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	r, err := store.Open(blob.ID)
+	assert.NoError(t, err)
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "contents", string(data))
+
+	_, err = store.Open("../../etc/passwd")
+	assert.Equal(t, ErrBlobNotFound, err, "Only blob IDs should be opened")
+
+	assert.NoError(t, store.Delete(blob.ID))
+	ok, _ = store.Exists(blob.ID)
+	assert.False(t, ok)
+	assert.Equal(t, ErrBlobNotFound, store.Delete(blob.ID))
+}
+
+func TestCreateBlob(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+	store, _ := NewFileBlobStore(dir)
+
+	_, err := createBlob(store, strings.NewReader(strings.Repeat("not a tar archive", 100)))
+	_, ok := err.(ValidationError)
+	assert.True(t, ok, "Anything but a tar archive should be rejected")
+
+	gzipped := &bytes.Buffer{}
+	gw := gzip.NewWriter(gzipped)
+	gw.Write(testArchive(t, "a.txt"))
+	gw.Close()
+	blob, err := createBlob(store, gzipped)
+	assert.NoError(t, err, "Gzipped archives should be accepted")
+	ok, _ = store.Exists(blob.ID)
+	assert.True(t, ok)
+}
+
+func TestRelocateArchive(t *testing.T) {
+	output := &bytes.Buffer{}
+	err := relocateArchive(bytes.NewReader(testArchive(t, "src/", "src/main.go", "README")), "/work/app/", output)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"work/app/src/", "work/app/src/main.go", "work/app/README"}, archiveNames(t, output.Bytes()))
+
+	output.Reset()
+	err = relocateArchive(bytes.NewReader(testArchive(t, "etc/motd")), "/", output)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"etc/motd"}, archiveNames(t, output.Bytes()))
+}
+
+func TestReadMultipartJob(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+	store, _ := NewFileBlobStore(dir)
+	existing, _ := createBlob(store, bytes.NewReader(testArchive(t, "existing.txt")))
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	w, _ := mw.CreateFormFile(multipartInputField, "src.tar")
+	w.Write(testArchive(t, "main.go"))
+	w, _ = mw.CreateFormField(multipartJobField)
+	w.Write([]byte(`{"image":"alpine","cmds":[{"args":["ls"]}],"inputs":[` +
+		`{"path":"/src"},{"path":"/data","blob":"` + string(existing.ID) + `"}]}`))
+	mw.Close()
+
+	job, blobs, err := readMultipartJob(store, multipart.NewReader(body, mw.Boundary()))
+	assert.NoError(t, err)
+	assert.Equal(t, "alpine", job.ImageName)
+	assert.Len(t, blobs, 1)
+	assert.Equal(t, blobs[0], job.Inputs[0].Blob, "The upload should fill in the input without a blob")
+	assert.Equal(t, existing.ID, job.Inputs[1].Blob)
+
+	body.Reset()
+	mw = multipart.NewWriter(body)
+	w, _ = mw.CreateFormField(multipartJobField)
+	w.Write([]byte(`{"image":"alpine","cmds":[{"args":["ls"]}]}`))
+	w, _ = mw.CreateFormFile(multipartInputField, "src.tar")
+	w.Write(testArchive(t, "main.go"))
+	mw.Close()
+
+	_, _, err = readMultipartJob(store, multipart.NewReader(body, mw.Boundary()))
+	_, ok := err.(ValidationError)
+	assert.True(t, ok, "An upload no input needs should be rejected")
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 2, "The rejected upload shouldn't be kept")
+}
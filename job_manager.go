@@ -1,59 +1,310 @@
 package dockworker
 
 import (
+	"sync"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
-	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// how long to wait for jobs to finish once
+	// they've been told to stop during a shutdown
+	shutdownStopTimeout = 30 * time.Second
+	// how often to check if running jobs have finished
+	runningJobsPollInterval = 250 * time.Millisecond
 )
 
 // JobManager manages Jobs
 type JobManager interface {
 	NotifyNewJob(job Job)
+	QueueStats() QueueStats
+	QueuePosition(ID JobID) (int, bool)
+	SetDraining(draining bool)
+	Draining() bool
 	Start()
 	Stop()
 }
 
-// NewJobManager returns a new JobManager
-func NewJobManager(jobStore JobStore, client *docker.Client, eventListner DockerEventListener, jobUpdater JobUpdater, stopEventListener StopEventListener) JobManager {
-	return jobManager{
+// QueueStats describes the state of the job queue
+type QueueStats struct {
+	Queued     int     `json:"queued"`
+	Running    int     `json:"running"`
+	MaxRunning int     `json:"max_running"`
+	Draining   bool    `json:"draining"`
+	QueuedJobs []JobID `json:"queued_jobs"`
+}
+
+// NewJobManager returns a new JobManager which runs at most
+// config.MaxRunningJobs jobs at once. The logs of the jobs are archived
+// in the logStore and their artifacts collected in the artifactStore,
+// unless they're nil. Their inputs are read from the blobStore, and
+// their images pulled with credentials from the registryAuth.
+func NewJobManager(jobStore JobStore, logStore LogStore, artifactStore ArtifactStore, blobStore BlobStore, registryAuth RegistryAuth, client Runtime, eventListner DockerEventListener, jobUpdater JobUpdater, stopEventListener StopEventListener, webhookSender WebhookSender, config Config) JobManager {
+	var archiver *logArchiver
+	if logStore != nil {
+		archiver = newLogArchiver(logStore, client, config.LogMaxBytes)
+	}
+	removeContainers := config.RemoveContainers
+	if removeContainers && archiver == nil {
+		log.Warn("Not removing the containers of jobs, since their logs aren't archived")
+		removeContainers = false
+	}
+	return &jobManager{
 		jobStore:          jobStore,
 		client:            client,
-		newJobs:           make(chan Job, 100),
+		lock:              &sync.Mutex{},
+		queue:             []Job{},
+		running:           make(map[JobID]*jobRunner),
+		maxRunning:        config.MaxRunningJobs,
+		gracePeriod:       config.ShutdownGracePeriod,
+		wake:              make(chan bool, 1),
+		stopChan:          make(chan JobID),
 		eventListner:      eventListner,
 		jobUpdater:        jobUpdater,
 		stopEventListener: stopEventListener,
+		webhookSender:     webhookSender,
+		logArchiver:       archiver,
+		artifactStore:     artifactStore,
+		blobStore:         blobStore,
+		registryAuth:      registryAuth,
+		removeContainers:  removeContainers,
+		removeImages:      config.RemoveImages,
+		cleaner: cleaner{
+			client:     client,
+			jobUpdater: jobUpdater,
+		},
 	}
 }
 
 type jobManager struct {
 	jobStore          JobStore
-	client            *docker.Client
-	newJobs           chan Job
+	client            Runtime
+	lock              *sync.Mutex
+	queue             []Job
+	running           map[JobID]*jobRunner
+	maxRunning        int
+	draining          bool
+	gracePeriod       time.Duration
+	wake              chan bool
+	stopChan          chan JobID
 	stopEventListener StopEventListener
 	eventListner      DockerEventListener
 	jobUpdater        JobUpdater
+	webhookSender     WebhookSender
+	logArchiver       *logArchiver
+	artifactStore     ArtifactStore
+	blobStore         BlobStore
+	registryAuth      RegistryAuth
+	removeContainers  bool
+	removeImages      ImageCleanup
+	cleaner           cleaner
 }
 
-func (jm jobManager) Start() {
+func (jm *jobManager) Start() {
 	log.Info("Job manager starting up...")
+	// stop requests all go through the manager, so a job
+	// being started can't miss one meant for it
+	jm.stopEventListener.RegisterListener(jm.stopChan)
+	jm.recover()
 	go jm.manager()
 }
 
-func (jm jobManager) Stop() {
-	// TODO: implement
+// Stop drains the job manager, giving running jobs the grace
+// period to finish before stopping the ones which are left.
+// Queued jobs stay queued.
+func (jm *jobManager) Stop() {
+	log.Info("Job manager shutting down...")
+	jm.SetDraining(true)
+	if jm.waitForRunningJobs(jm.gracePeriod) {
+		log.Info("All running jobs finished")
+		return
+	}
+
+	jm.lock.Lock()
+	log.Infof("Grace period of %s is over, stopping %d running jobs", jm.gracePeriod, len(jm.running))
+	for _, jr := range jm.running {
+		jr.abort(JobStatusStopped, "Stopped because the server shut down")
+	}
+	jm.lock.Unlock()
+
+	if !jm.waitForRunningJobs(shutdownStopTimeout) {
+		log.Warn("Gave up waiting for jobs to stop")
+	}
 }
 
-func (jm jobManager) NotifyNewJob(job Job) {
+func (jm *jobManager) NotifyNewJob(job Job) {
 	log.Debugf("Notifying new job %d", job.ID)
-	jm.newJobs <- job
+	jm.lock.Lock()
+	jm.queue = append(jm.queue, job)
+	jm.lock.Unlock()
+	jm.wakeManager()
 }
 
-func (jm jobManager) manager() {
+func (jm *jobManager) QueueStats() QueueStats {
+	jm.lock.Lock()
+	defer jm.lock.Unlock()
+	stats := QueueStats{
+		Queued:     len(jm.queue),
+		Running:    len(jm.running),
+		MaxRunning: jm.maxRunning,
+		Draining:   jm.draining,
+		QueuedJobs: make([]JobID, 0, len(jm.queue)),
+	}
+	for _, job := range jm.queue {
+		stats.QueuedJobs = append(stats.QueuedJobs, job.ID)
+	}
+	return stats
+}
+
+// QueuePosition returns the position of the job in the queue,
+// starting at 1 for the next job to run, or false if it isn't queued
+func (jm *jobManager) QueuePosition(ID JobID) (int, bool) {
+	jm.lock.Lock()
+	defer jm.lock.Unlock()
+	for i, job := range jm.queue {
+		if job.ID == ID {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// SetDraining sets whether the manager is draining. While draining,
+// no new jobs are accepted and queued jobs aren't started.
+func (jm *jobManager) SetDraining(draining bool) {
+	jm.lock.Lock()
+	if jm.draining != draining {
+		log.Infof("Setting draining to %t", draining)
+	}
+	jm.draining = draining
+	jm.lock.Unlock()
+	jm.wakeManager()
+}
+
+func (jm *jobManager) Draining() bool {
+	jm.lock.Lock()
+	defer jm.lock.Unlock()
+	return jm.draining
+}
+
+// wakeManager lets the manager know the queue or the
+// running jobs have changed without ever blocking
+func (jm *jobManager) wakeManager() {
+	select {
+	case jm.wake <- true:
+	default:
+		// the manager already has a wake up pending
+	}
+}
+
+func (jm *jobManager) manager() {
 	for {
 		select {
-		case job := <-jm.newJobs:
-			// start new job worker
-			log.Debugf("Starting new job %d", job.ID)
-			go jm.jobWorker(job)
+		case <-jm.wake:
+			jm.startQueuedJobs()
+		case ID := <-jm.stopChan:
+			jm.handleStopRequest(ID)
+		}
+	}
+}
+
+// startQueuedJobs starts as many queued jobs as there are free slots
+func (jm *jobManager) startQueuedJobs() {
+	jm.lock.Lock()
+	defer jm.lock.Unlock()
+	for !jm.draining && len(jm.queue) > 0 && (jm.maxRunning <= 0 || len(jm.running) < jm.maxRunning) {
+		job := jm.queue[0]
+		jm.queue = jm.queue[1:]
+		// start new job worker
+		log.Debugf("Starting new job %d", job.ID)
+		jr, err := jm.newJobRunner(job)
+		if err != nil {
+			log.Errorf("Error creating job runner: %s", err)
+			jm.jobUpdater.UpdateStatus(&job, JobStatusFailed)
+			continue
+		}
+		jm.running[job.ID] = jr
+		go jm.jobWorker(jr)
+	}
+	log.Debugf("%d jobs running, %d jobs queued", len(jm.running), len(jm.queue))
+}
+
+// handleStopRequest takes a queued job out of the queue, or asks
+// the runner of a running job to stop it. Both are done under the
+// lock, so a job being started is always found in one or the other.
+func (jm *jobManager) handleStopRequest(ID JobID) {
+	jm.lock.Lock()
+	if jr, ok := jm.running[ID]; ok {
+		jr.abort(JobStatusStopped, "")
+		jm.lock.Unlock()
+		return
+	}
+	var job Job
+	found := false
+	for i, j := range jm.queue {
+		if j.ID == ID {
+			job = j
+			found = true
+			jm.queue = append(jm.queue[:i], jm.queue[i+1:]...)
+			break
+		}
+	}
+	jm.lock.Unlock()
+	if !found {
+		// the job is done already
+		return
+	}
+
+	log.Infof("Stopping queued job %d", ID)
+	jm.jobUpdater.UpdateMessage(&job, "Stopped while queued")
+	jm.jobUpdater.UpdateStatus(&job, JobStatusStopped)
+	jm.webhookSender.Send(job)
+}
+
+// jobFinished frees up the slot of a job which is done running
+func (jm *jobManager) jobFinished(ID JobID) {
+	jm.lock.Lock()
+	delete(jm.running, ID)
+	jm.lock.Unlock()
+	jm.wakeManager()
+}
+
+// cleanupJob removes what the configuration says to of a job which is done
+func (jm *jobManager) cleanupJob(job *Job) {
+	report := newCleanupReport()
+	if jm.removeContainers {
+		jm.cleaner.removeContainers(job, report)
+	}
+	switch jm.removeImages {
+	case ImageCleanupIntermediate:
+		keep := ImageName("")
+		if len(job.Images) > 0 {
+			keep = job.Images[len(job.Images)-1]
+		}
+		jm.cleaner.removeImages(job, keep, report)
+	case ImageCleanupAll:
+		jm.cleaner.removeImages(job, "", report)
+	}
+	if len(report.Jobs) > 0 {
+		log.Infof("Removed %d containers and %d images of job %d", len(report.Containers), len(report.Images), job.ID)
+	}
+}
+
+// waitForRunningJobs waits up to the timeout for the running
+// jobs to finish, returning whether they all did
+func (jm *jobManager) waitForRunningJobs(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		jm.lock.Lock()
+		running := len(jm.running)
+		jm.lock.Unlock()
+		if running == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
 		}
+		time.Sleep(runningJobsPollInterval)
 	}
 }
@@ -0,0 +1,168 @@
+package dockworker
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// JobIDLabel is the label on every job container
+	// holding the ID of the job it belongs to
+	JobIDLabel = "dockworker.job-id"
+	// CmdIndexLabel is the label on every job container holding
+	// the index of the command of the job it runs
+	CmdIndexLabel = "dockworker.cmd-index"
+)
+
+func containerLabels(ID JobID, cmdIndex int) map[string]string {
+	return map[string]string{
+		JobIDLabel:    strconv.Itoa(int(ID)),
+		CmdIndexLabel: strconv.Itoa(cmdIndex),
+	}
+}
+
+// recover reconciles the jobs which were queued or running when
+// the server last stopped with the state of their containers
+func (jm *jobManager) recover() {
+	jobs, err := jm.jobStore.List(JobFilter{
+		Statuses: []JobStatus{JobStatusQueued, JobStatusRunning},
+	})
+	if err != nil {
+		log.Errorf("Failed to list unfinished jobs for recovery: %s", err)
+		return
+	}
+	containers, err := jm.jobContainers()
+	if err != nil {
+		log.Errorf("Failed to list job containers for recovery: %s", err)
+		// carry on, we can still inspect the containers we know about
+		containers = make(map[JobID][]docker.APIContainers)
+	}
+
+	unfinished := make(map[JobID]bool)
+	for _, job := range jobs {
+		unfinished[job.ID] = true
+		switch job.Status {
+		case JobStatusQueued:
+			log.Infof("Re-queueing job %d", job.ID)
+			jm.NotifyNewJob(job)
+		case JobStatusRunning:
+			jm.reconcile(job, containers[job.ID])
+		}
+	}
+
+	for ID, jobContainers := range containers {
+		if unfinished[ID] {
+			continue
+		}
+		for _, c := range jobContainers {
+			jm.stopOrphan(ID, c)
+		}
+	}
+}
+
+// reconcile decides how to carry on with a job which was running,
+// given the labelled containers found for it
+func (jm *jobManager) reconcile(job Job, labelled []docker.APIContainers) {
+	known := make(map[string]bool)
+	for _, c := range job.Containers {
+		known[string(c)] = true
+	}
+	for _, c := range labelled {
+		if known[c.ID] {
+			continue
+		}
+		if c.Labels[CmdIndexLabel] == strconv.Itoa(len(job.Containers)) {
+			// the container was created just before we went
+			// down, but we never got the chance to record it
+			log.Infof("Adopting unrecorded container %s of job %d", c.ID, job.ID)
+			jm.jobUpdater.AddContainer(&job, Container(c.ID))
+			known[c.ID] = true
+			continue
+		}
+		jm.stopOrphan(job.ID, c)
+	}
+
+	if len(job.Containers) == 0 {
+		// we never got as far as running a command
+		jm.requeue(job, "Re-queued after restart before any command ran")
+		return
+	}
+
+	last := string(job.Containers[len(job.Containers)-1])
+	container, err := jm.client.InspectContainer(last)
+	if err != nil {
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			jm.requeue(job, fmt.Sprintf("Re-queued after restart, container %s no longer exists", last))
+			return
+		}
+		log.Errorf("Error inspecting container %s of job %d: %s", last, job.ID, err)
+		jm.jobUpdater.UpdateStatus(&job, JobStatusError)
+		return
+	}
+	if !container.State.Running && container.State.StartedAt.IsZero() {
+		// we went down between creating and starting the container,
+		// so it has nothing to report and no die event is coming
+		jm.requeue(job, fmt.Sprintf("Re-queued after restart, container %s never started", last))
+		return
+	}
+
+	log.Infof("Resuming job %d with container %s", job.ID, last)
+	jr, err := jm.newJobRunner(job)
+	if err != nil {
+		log.Errorf("Error creating job runner: %s", err)
+		jm.jobUpdater.UpdateStatus(&job, JobStatusFailed)
+		return
+	}
+	jm.lock.Lock()
+	jm.running[job.ID] = jr
+	jm.lock.Unlock()
+	go jm.resumeWorker(jr, container)
+}
+
+// requeue starts a job over from the beginning
+func (jm *jobManager) requeue(job Job, message string) {
+	log.Infof("Re-queueing job %d: %s", job.ID, message)
+	if err := jm.jobUpdater.Requeue(&job, message); err != nil {
+		return
+	}
+	jm.NotifyNewJob(job)
+}
+
+// jobContainers finds every container labelled as belonging to a job
+func (jm *jobManager) jobContainers() (map[JobID][]docker.APIContainers, error) {
+	containers, err := jm.client.ListContainers(docker.ListContainersOptions{
+		All: true,
+		Filters: map[string][]string{
+			"label": []string{JobIDLabel},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	byJob := make(map[JobID][]docker.APIContainers)
+	for _, c := range containers {
+		ID, err := strconv.Atoi(c.Labels[JobIDLabel])
+		if err != nil {
+			log.Warnf("Container %s has invalid job ID label %q", c.ID, c.Labels[JobIDLabel])
+			continue
+		}
+		byJob[JobID(ID)] = append(byJob[JobID(ID)], c)
+	}
+	return byJob, nil
+}
+
+// stopOrphan stops a container no unfinished job is responsible for
+func (jm *jobManager) stopOrphan(ID JobID, c docker.APIContainers) {
+	log.Infof("Stopping orphaned container %s of job %d", c.ID, ID)
+	err := jm.client.StopContainer(c.ID, 5)
+	if err == nil {
+		return
+	}
+	if _, ok := err.(*docker.ContainerNotRunning); ok {
+		return
+	}
+	log.Errorf("Error stopping orphaned container %s of job %d: %s", c.ID, ID, err)
+}
@@ -1,40 +1,156 @@
 package dockworker
 
+import (
+	"path"
+	"time"
+)
+
 // JobService handles the jobs
 type JobService interface {
 	Add(job Job) (Job, error)
 	Find(ID JobID) (Job, error)
+	List(filter JobFilter) (JobList, error)
+	QueueStats() QueueStats
 	UpdateStatus(job Job) error
 }
 
 // NewJobService returns a new JobService
-func NewJobService(jobStore JobStore, jobManager JobManager) JobService {
+// The artifactStore is nil if artifacts can't be collected,
+// and the blobStore is nil if jobs can't have inputs. New jobs
+// are checked against the resource limits, mount policy and
+// network policy of the config.
+func NewJobService(jobStore JobStore, jobManager JobManager, artifactStore ArtifactStore,
+	blobStore BlobStore, registryAuth RegistryAuth, config Config) JobService {
 	return jobService{
-		jobStore:   jobStore,
-		jobManager: jobManager,
+		jobStore:      jobStore,
+		jobManager:    jobManager,
+		artifactStore: artifactStore,
+		blobStore:     blobStore,
+		registryAuth:  registryAuth,
+		limits:        config.ResourceLimits,
+		mountPolicy:   config.MountPolicy,
+		networkPolicy: config.NetworkPolicy,
 	}
 }
 
 type jobService struct {
-	jobStore   JobStore
-	jobManager JobManager
+	jobStore      JobStore
+	jobManager    JobManager
+	artifactStore ArtifactStore
+	blobStore     BlobStore
+	registryAuth  RegistryAuth
+	limits        ResourceLimits
+	mountPolicy   MountPolicy
+	networkPolicy NetworkPolicy
 }
 
 func (service jobService) Add(job Job) (Job, error) {
-	// TODO: validations
+	if service.jobManager.Draining() {
+		return Job{}, ErrDraining
+	}
+	if err := validateJob(job); err != nil {
+		return Job{}, err
+	}
+	clearServerFields(&job)
+	if len(job.Artifacts) > 0 && service.artifactStore == nil {
+		return Job{}, validationErrorf("Artifacts can't be collected, no artifact directory is configured")
+	}
+	if err := service.checkInputs(job); err != nil {
+		return Job{}, err
+	}
+	if err := service.mountPolicy.apply(&job); err != nil {
+		return Job{}, err
+	}
+	if err := service.networkPolicy.apply(&job); err != nil {
+		return Job{}, err
+	}
+	pullPolicy, err := parsePullPolicy(job.PullPolicy, job.ImageName)
+	if err != nil {
+		return Job{}, err
+	}
+	job.PullPolicy = pullPolicy
+	if job.RegistryCredentials != "" && !service.registryAuth.HasCredentials(job.RegistryCredentials) {
+		return Job{}, validationErrorf("There are no registry credentials named %q", job.RegistryCredentials)
+	}
+	resources := Resources{}
+	if job.Resources != nil {
+		resources = *job.Resources
+	}
+	resources, err = service.limits.apply(resources)
+	if err != nil {
+		return Job{}, err
+	}
+	job.Resources = nil
+	if !resources.isZero() {
+		job.Resources = &resources
+	}
 	job.Status = JobStatusQueued
-	job, err := service.jobStore.Add(job)
+	job.CreateTime = time.Now()
+	job, err = service.jobStore.Add(job)
 	if err != nil {
 		return Job{}, err
 	}
 
 	service.jobManager.NotifyNewJob(job)
 
-	return job, nil
+	return service.withQueuePosition(job), nil
+}
+
+// clearServerFields resets what only the server sets on a job, so a
+// client can't make it resume from, or clean up, things it names
+func clearServerFields(job *Job) {
+	job.Message = ""
+	job.Results = nil
+	job.Containers = nil
+	job.Images = nil
+	job.Attempts = nil
+	job.StartTime = time.Time{}
+	job.EndTime = time.Time{}
+	job.Provenance = Provenance{}
+	job.RemovedContainers = nil
+	job.RemovedImages = nil
+	job.QueuePosition = 0
 }
 
 func (service jobService) Find(ID JobID) (Job, error) {
-	return service.jobStore.Find(ID)
+	job, err := service.jobStore.Find(ID)
+	if err != nil {
+		return Job{}, err
+	}
+	return service.withQueuePosition(job), nil
+}
+
+func (service jobService) List(filter JobFilter) (JobList, error) {
+	// ask for one more job than needed to know if there's another page
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit = limit + 1
+	}
+	jobs, err := service.jobStore.List(filter)
+	if err != nil {
+		return JobList{}, err
+	}
+	list := JobList{Jobs: jobs}
+	if limit > 0 && len(jobs) > limit {
+		list.Jobs = jobs[:limit]
+		next := list.Jobs[limit-1].ID
+		list.Next = &next
+	}
+	return list, nil
+}
+
+func (service jobService) QueueStats() QueueStats {
+	return service.jobManager.QueueStats()
+}
+
+func (service jobService) withQueuePosition(job Job) Job {
+	if job.Status != JobStatusQueued {
+		return job
+	}
+	if position, ok := service.jobManager.QueuePosition(job.ID); ok {
+		job.QueuePosition = position
+	}
+	return job
 }
 
 func (service jobService) UpdateStatus(job Job) error {
@@ -46,3 +162,63 @@ func (service jobService) UpdateStatus(job Job) error {
 	j.Status = job.Status
 	return service.jobStore.Update(j)
 }
+
+// validateJob checks a submitted job makes sense
+func validateJob(job Job) error {
+	if err := validateImageName(job.ImageName); err != nil {
+		return err
+	}
+	if job.Timeout < 0 {
+		return validationErrorf("Timeout must not be negative")
+	}
+	if job.Retry != nil {
+		if err := job.Retry.validate(); err != nil {
+			return err
+		}
+	}
+	if job.Workdir != "" && !path.IsAbs(job.Workdir) {
+		return validationErrorf("Workdir must be an absolute path")
+	}
+	for i, cmd := range job.Cmds {
+		if cmd.Timeout < 0 {
+			return validationErrorf("Timeout of command %d must not be negative", i)
+		}
+		if cmd.Workdir != "" && !path.IsAbs(cmd.Workdir) {
+			return validationErrorf("Workdir of command %d must be an absolute path", i)
+		}
+	}
+	for i, input := range job.Inputs {
+		if !path.IsAbs(input.Path) {
+			return validationErrorf("Path of input %d must be absolute", i)
+		}
+		if input.Blob == "" {
+			return validationErrorf("Input %d must have a blob", i)
+		}
+	}
+	for _, artifact := range job.Artifacts {
+		if !path.IsAbs(artifact) || path.Clean(artifact) == "/" {
+			return validationErrorf("Artifact %q must be an absolute path below /", artifact)
+		}
+	}
+	return nil
+}
+
+// checkInputs returns a ValidationError unless every blob the inputs of the job use exists
+func (service jobService) checkInputs(job Job) error {
+	if len(job.Inputs) == 0 {
+		return nil
+	}
+	if service.blobStore == nil {
+		return validationErrorf("Jobs can't have inputs, no blob directory is configured")
+	}
+	for i, input := range job.Inputs {
+		ok, err := service.blobStore.Exists(input.Blob)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return validationErrorf("Blob %s of input %d doesn't exist", input.Blob, i)
+		}
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ type JobStore interface {
 	Add(job Job) (Job, error)
 	Find(ID JobID) (Job, error)
 	Update(job Job) error
+	List(filter JobFilter) ([]Job, error)
 }
 
 // NewJobStore creates a new JobStore
@@ -53,3 +54,9 @@ func (store inMemJobStore) Update(job Job) error {
 	store.data[job.ID] = job
 	return nil
 }
+
+func (store inMemJobStore) List(filter JobFilter) ([]Job, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return filterJobs(store.data, filter), nil
+}
@@ -0,0 +1,220 @@
+package dockworker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemJobStore(t *testing.T) {
+	testJobStore(t, NewJobStore())
+	testJobStoreList(t, NewJobStore())
+}
+
+func TestFileJobStore(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileJobStore(dir)
+	assert.NoError(t, err)
+	testJobStore(t, store)
+
+	listDir := tempDataDir(t)
+	defer os.RemoveAll(listDir)
+	store, err = NewFileJobStore(listDir)
+	assert.NoError(t, err)
+	testJobStoreList(t, store)
+}
+
+func TestFileJobStoreReopen(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileJobStore(dir)
+	assert.NoError(t, err)
+	first, _ := store.Add(Job{ImageName: "ubuntu:14.04"})
+	second, _ := store.Add(Job{ImageName: "ubuntu:16.04"})
+	second.Status = JobStatusSuccessful
+	second.Results = []CmdResult{0, 0}
+	assert.NoError(t, store.Update(second))
+
+	reopened, err := NewFileJobStore(dir)
+	assert.NoError(t, err)
+	j, err := reopened.Find(first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ImageName, j.ImageName, "Jobs should survive reopening the store")
+	j, err = reopened.Find(second.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, JobStatusSuccessful, j.Status, "Updates should survive reopening the store")
+	assert.Equal(t, []CmdResult{0, 0}, j.Results, "Updates should survive reopening the store")
+
+	third, _ := reopened.Add(Job{})
+	assert.Equal(t, second.ID+1, third.ID, "IDs should keep increasing after reopening the store")
+}
+
+func TestFileJobStorePartialWrite(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileJobStore(dir)
+	assert.NoError(t, err)
+	job, _ := store.Add(Job{ImageName: "ubuntu:14.04"})
+
+	// simulate the server dying in the middle of a write
+	f, err := os.OpenFile(store.(*fileJobStore).path, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	f.WriteString(`{"next_id": 5, "job": {"id": 4, "ima`)
+	f.Close()
+
+	reopened, err := NewFileJobStore(dir)
+	assert.NoError(t, err)
+	j, err := reopened.Find(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, job.ImageName, j.ImageName, "Complete entries should be kept")
+	_, err = reopened.Find(4)
+	assert.Equal(t, ErrJobNotFound, err, "Partial entries should be dropped")
+}
+
+func TestFileJobStoreCorruptRecord(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileJobStore(dir)
+	assert.NoError(t, err)
+	store.Add(Job{ImageName: "ubuntu:14.04"})
+	path := store.(*fileJobStore).path
+
+	// a corrupt record followed by good ones
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	f.WriteString("{\"next_id\": 5, \"job\": {\"id\": 4, \"ima\n")
+	f.Close()
+	store.Add(Job{ImageName: "ubuntu:16.04"})
+	before, _ := ioutil.ReadFile(path)
+
+	_, err = NewFileJobStore(dir)
+	assert.Error(t, err, "A corrupt record in the middle of the log should stop the store opening")
+	after, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(before), string(after), "The log shouldn't be compacted when it's corrupt")
+}
+
+func TestFileJobStoreCompaction(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileJobStore(dir)
+	assert.NoError(t, err)
+	store.(*fileJobStore).minCompactRecords = 10
+	first, _ := store.Add(Job{ImageName: "ubuntu:14.04"})
+	second, _ := store.Add(Job{ImageName: "ubuntu:16.04"})
+	for i := 0; i < 100; i++ {
+		second.Results = append(second.Results, CmdResult(i))
+		assert.NoError(t, store.Update(second))
+	}
+
+	data, err := ioutil.ReadFile(store.(*fileJobStore).path)
+	assert.NoError(t, err)
+	lines := bytes.Count(data, []byte("\n"))
+	assert.True(t, lines < 20, "The job log should be compacted as it grows, it has %d records", lines)
+
+	reopened, err := NewFileJobStore(dir)
+	assert.NoError(t, err)
+	j, err := reopened.Find(first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ImageName, j.ImageName, "Jobs should survive compaction")
+	j, err = reopened.Find(second.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, len(j.Results), "The latest update should survive compaction")
+	third, _ := reopened.Add(Job{})
+	assert.Equal(t, second.ID+1, third.ID, "IDs should keep increasing after compaction")
+}
+
+// testJobStore checks the behaviour every JobStore implementation must have
+func testJobStore(t *testing.T, store JobStore) {
+	first, err := store.Add(Job{ImageName: "ubuntu:14.04", Status: JobStatusQueued})
+	assert.NoError(t, err)
+	second, err := store.Add(Job{ImageName: "ubuntu:16.04", Status: JobStatusQueued})
+	assert.NoError(t, err)
+	assert.True(t, first.ID < second.ID, "IDs should increase")
+
+	j, err := store.Find(first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, first, j, "Found job should match the added job")
+
+	_, err = store.Find(second.ID + 1)
+	assert.Equal(t, ErrJobNotFound, err, "Finding a missing job should fail")
+
+	first.Status = JobStatusRunning
+	first.Containers = []Container{"abc"}
+	assert.NoError(t, store.Update(first))
+	j, err = store.Find(first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, first, j, "Found job should match the updated job")
+
+	j, err = store.Find(second.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, second, j, "Updates should not affect other jobs")
+
+	err = store.Update(Job{ID: second.ID + 1})
+	assert.Equal(t, ErrJobNotFound, err, "Updating a missing job should fail")
+}
+
+// testJobStoreList checks the listing behaviour every
+// JobStore implementation must have
+func testJobStoreList(t *testing.T, store JobStore) {
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		job := Job{
+			ImageName:  "ubuntu:14.04",
+			Status:     JobStatusQueued,
+			Labels:     map[string]string{"parity": "even"},
+			CreateTime: start.Add(time.Duration(i) * time.Minute),
+		}
+		if i%2 == 1 {
+			job.ImageName = "ubuntu:16.04"
+			job.Status = JobStatusRunning
+			job.Labels["parity"] = "odd"
+		}
+		store.Add(job)
+	}
+
+	jobs, err := store.List(JobFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []JobID{0, 1, 2, 3, 4}, jobIDs(jobs), "All jobs should be listed in order")
+
+	jobs, _ = store.List(JobFilter{Statuses: []JobStatus{JobStatusRunning}})
+	assert.Equal(t, []JobID{1, 3}, jobIDs(jobs), "Jobs should be filtered by status")
+
+	jobs, _ = store.List(JobFilter{ImageName: "ubuntu:14.04"})
+	assert.Equal(t, []JobID{0, 2, 4}, jobIDs(jobs), "Jobs should be filtered by image")
+
+	jobs, _ = store.List(JobFilter{Labels: map[string]string{"parity": "odd"}})
+	assert.Equal(t, []JobID{1, 3}, jobIDs(jobs), "Jobs should be filtered by label")
+
+	jobs, _ = store.List(JobFilter{CreatedAfter: start, CreatedBefore: start.Add(3 * time.Minute)})
+	assert.Equal(t, []JobID{1, 2}, jobIDs(jobs), "Jobs should be filtered by create time")
+
+	after := JobID(1)
+	jobs, _ = store.List(JobFilter{After: &after, Limit: 2})
+	assert.Equal(t, []JobID{2, 3}, jobIDs(jobs), "Jobs should be paginated")
+}
+
+func jobIDs(jobs []Job) []JobID {
+	IDs := []JobID{}
+	for _, job := range jobs {
+		IDs = append(IDs, job.ID)
+	}
+	return IDs
+}
+
+func tempDataDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "dockworker")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+	return dir
+}
@@ -0,0 +1,68 @@
+package dockworker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdJSON(t *testing.T) {
+	cases := []struct {
+		json string
+		cmd  Cmd
+	}{
+		{`["echo","test"]`, Cmd{Args: []string{"echo", "test"}}},
+		{`{"args":["sleep","30"],"timeout":5}`, Cmd{Args: []string{"sleep", "30"}, Timeout: 5}},
+		{`{"args":["make"],"workdir":"/src","user":"nobody","entrypoint":["sh","-c"]}`,
+			Cmd{Args: []string{"make"}, Workdir: "/src", User: "nobody", Entrypoint: []string{"sh", "-c"}}},
+	}
+	for i, tc := range cases {
+		cmd := Cmd{}
+		assert.NoError(t, json.Unmarshal([]byte(tc.json), &cmd), "Case %d: Command should decode", i)
+		assert.Equal(t, tc.cmd, cmd, "Case %d: Decoded command should match", i)
+
+		encoded, err := json.Marshal(tc.cmd)
+		assert.NoError(t, err, "Case %d: Command should encode", i)
+		assert.Equal(t, tc.json, string(encoded), "Case %d: Encoded command should match", i)
+	}
+
+	cmd := Cmd{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"args": ["true"]}`), &cmd))
+	encoded, _ := json.Marshal(cmd)
+	assert.Equal(t, `["true"]`, string(encoded), "Commands without options should encode as arrays")
+}
+
+func TestResolveCmdOptions(t *testing.T) {
+	image := &docker.Config{WorkingDir: "/", User: "root", Entrypoint: []string{"/entrypoint.sh"}}
+	job := Job{
+		Workdir: "/src",
+		Cmds: []Cmd{
+			{Args: []string{"make"}},
+			{Args: []string{"id"}, User: "nobody", Entrypoint: []string{"sh", "-c"}},
+			{Args: []string{"ls"}, Workdir: "/out"},
+		},
+	}
+	assert.True(t, hasCmdOptions(job))
+	assert.False(t, hasCmdOptions(Job{Cmds: []Cmd{{Args: []string{"ls"}}}}))
+
+	opts := resolveCmdOptions(job, 0, image)
+	assert.Equal(t, cmdOptions{workdir: "/src", user: "root", entrypoint: []string{"/entrypoint.sh"}}, opts,
+		"Options the job doesn't set should come from the image")
+
+	opts = resolveCmdOptions(job, 1, image)
+	assert.Equal(t, cmdOptions{workdir: "/src", user: "nobody", entrypoint: []string{"sh", "-c"}}, opts,
+		"Options the command sets should override the job's")
+
+	config := &docker.Config{}
+	resolveCmdOptions(job, 2, image).apply(config)
+	assert.Equal(t, "/out", config.WorkingDir)
+	assert.Equal(t, "root", config.User, "Options of earlier commands shouldn't carry over")
+	assert.Equal(t, []string{"/entrypoint.sh"}, config.Entrypoint)
+
+	config = &docker.Config{}
+	resolveCmdOptions(job, 2, &docker.Config{}).apply(config)
+	assert.Equal(t, []string{""}, config.Entrypoint,
+		"An entrypoint an earlier command set should be reset when the image has none")
+}
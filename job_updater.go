@@ -9,122 +9,230 @@ import (
 // JobUpdater handles updating jobs
 type JobUpdater interface {
 	UpdateStatus(job *Job, status JobStatus) error
+	UpdateMessage(job *Job, message string) error
 	UpdateStartTime(job *Job, startTime time.Time) error
 	UpdateEndTime(job *Job, endTime time.Time) error
 	AddCmdResult(job *Job, result CmdResult) error
 	AddContainer(job *Job, container Container) error
 	AddImage(job *Job, image ImageName) error
+	// SetProvenance records what the job runs with
+	SetProvenance(job *Job, provenance Provenance) error
+	AddRemovedContainer(job *Job, container Container) error
+	AddRemovedImage(job *Job, image ImageName) error
+	Requeue(job *Job, message string) error
+	StartAttempt(job *Job, message string) error
 }
 
-// NewJobUpdater returns a new JobUpdater
-func NewJobUpdater(jobStore JobStore) JobUpdater {
+// NewJobUpdater returns a new JobUpdater which
+// publishes every update it makes to the eventBus
+func NewJobUpdater(jobStore JobStore, eventBus JobEventBus) JobUpdater {
 	return jobUpdater{
 		jobStore: jobStore,
+		eventBus: eventBus,
 	}
 }
 
 type jobUpdater struct {
 	jobStore JobStore
+	eventBus JobEventBus
 }
 
 func (ju jobUpdater) UpdateEndTime(job *Job, endTime time.Time) error {
-	// make sure nothing but the status gets updated
-	j, err := ju.jobStore.Find(job.ID)
+	err := ju.modify(job, "end time", func(j *Job) {
+		j.EndTime = endTime
+	})
 	if err != nil {
-		log.Errorf("Error finding job during end time update %d: %s", job.ID, err)
 		return err
 	}
-	// TODO: this is redundant, see if we can improve
-	job.EndTime = endTime
-	j.EndTime = endTime
-	err = ju.jobStore.Update(j)
+	ju.publish(job, JobEvent{Type: JobEventEndTime, EndTime: &endTime})
+	return nil
+}
+
+func (ju jobUpdater) UpdateStartTime(job *Job, startTime time.Time) error {
+	err := ju.modify(job, "start time", func(j *Job) {
+		j.StartTime = startTime
+	})
 	if err != nil {
-		log.Errorf("Error updating job end time %d: %s", job.ID, err)
 		return err
 	}
+	ju.publish(job, JobEvent{Type: JobEventStartTime, StartTime: &startTime})
 	return nil
 }
 
-func (ju jobUpdater) UpdateStartTime(job *Job, startTime time.Time) error {
-	// make sure nothing but the status gets updated
-	j, err := ju.jobStore.Find(job.ID)
+func (ju jobUpdater) UpdateStatus(job *Job, status JobStatus) error {
+	err := ju.modify(job, "status", func(j *Job) {
+		j.Status = status
+	})
 	if err != nil {
-		log.Errorf("Error finding job during start time update %d: %s", job.ID, err)
 		return err
 	}
-	// TODO: this is redundant, see if we can improve
-	job.StartTime = startTime
-	j.StartTime = startTime
-	err = ju.jobStore.Update(j)
+	ju.publish(job, JobEvent{Type: JobEventStatus})
+	return nil
+}
+
+func (ju jobUpdater) UpdateMessage(job *Job, message string) error {
+	err := ju.modify(job, "message", func(j *Job) {
+		j.Message = message
+	})
 	if err != nil {
-		log.Errorf("Error updating job start time %d: %s", job.ID, err)
 		return err
 	}
+	ju.publish(job, JobEvent{Type: JobEventMessage, Message: message})
 	return nil
 }
 
-func (ju jobUpdater) UpdateStatus(job *Job, status JobStatus) error {
-	// make sure nothing but the status gets updated
-	j, err := ju.jobStore.Find(job.ID)
+func (ju jobUpdater) AddCmdResult(job *Job, result CmdResult) error {
+	err := ju.modify(job, "results", func(j *Job) {
+		j.Results = append(j.Results, result)
+	})
 	if err != nil {
-		log.Errorf("Error finding job during status update %d: %s", job.ID, err)
 		return err
 	}
-	// TODO: this is redundant, see if we can improve
-	job.Status = status
-	j.Status = status
-	err = ju.jobStore.Update(j)
+	ju.publish(job, JobEvent{Type: JobEventCmdResult, Result: &result})
+	return nil
+}
+
+func (ju jobUpdater) AddImage(job *Job, image ImageName) error {
+	err := ju.modify(job, "images", func(j *Job) {
+		j.Images = append(j.Images, image)
+	})
 	if err != nil {
-		log.Errorf("Error updating job status %d: %s", job.ID, err)
 		return err
 	}
+	ju.publish(job, JobEvent{Type: JobEventImage, Image: image})
 	return nil
 }
 
-func (ju jobUpdater) AddCmdResult(job *Job, result CmdResult) error {
-	j, err := ju.jobStore.Find(job.ID)
+func (ju jobUpdater) SetProvenance(job *Job, provenance Provenance) error {
+	err := ju.modify(job, "provenance", func(j *Job) {
+		j.Provenance = provenance
+	})
 	if err != nil {
-		log.Errorf("Error finding job during results update %d: %s", job.ID, err)
 		return err
 	}
-	job.Results = append(job.Results, result)
-	j.Results = append(j.Results, result)
-	err = ju.jobStore.Update(j)
+	ju.publish(job, JobEvent{Type: JobEventImageResolved, Image: provenance.ImageID, Digest: provenance.ImageDigest})
+	return nil
+}
+
+func (ju jobUpdater) AddContainer(job *Job, container Container) error {
+	err := ju.modify(job, "containers", func(j *Job) {
+		j.Containers = append(j.Containers, container)
+	})
 	if err != nil {
-		log.Errorf("Error updating job results %d: %s", job.ID, err)
 		return err
 	}
+	ju.publish(job, JobEvent{Type: JobEventContainer, Container: container})
 	return nil
 }
 
-func (ju jobUpdater) AddImage(job *Job, image ImageName) error {
-	j, err := ju.jobStore.Find(job.ID)
+func (ju jobUpdater) AddRemovedContainer(job *Job, container Container) error {
+	err := ju.modify(job, "removed containers", func(j *Job) {
+		// the job may be cleaned up more than once
+		if !containsContainer(j.RemovedContainers, container) {
+			j.RemovedContainers = append(j.RemovedContainers, container)
+		}
+	})
 	if err != nil {
-		log.Errorf("Error finding job during images update %d: %s", job.ID, err)
 		return err
 	}
-	job.Images = append(job.Images, image)
-	j.Images = append(j.Images, image)
-	err = ju.jobStore.Update(j)
+	ju.publish(job, JobEvent{Type: JobEventContainerRemoved, Container: container})
+	return nil
+}
+
+func (ju jobUpdater) AddRemovedImage(job *Job, image ImageName) error {
+	err := ju.modify(job, "removed images", func(j *Job) {
+		// the job may be cleaned up more than once
+		if !containsImage(j.RemovedImages, image) {
+			j.RemovedImages = append(j.RemovedImages, image)
+		}
+	})
 	if err != nil {
-		log.Errorf("Error updating job images %d: %s", job.ID, err)
 		return err
 	}
+	ju.publish(job, JobEvent{Type: JobEventImageRemoved, Image: image})
 	return nil
 }
 
-func (ju jobUpdater) AddContainer(job *Job, container Container) error {
-	j, err := ju.jobStore.Find(job.ID)
+func (ju jobUpdater) Requeue(job *Job, message string) error {
+	err := ju.modify(job, "requeue", func(j *Job) {
+		if len(j.Containers) > 0 || len(j.Images) > 0 {
+			// keep the interrupted run, so what it
+			// left behind can still be cleaned up
+			attempt := currentAttempt(*j)
+			attempt.Message = message
+			j.Attempts = append(j.Attempts, attempt)
+		}
+		resetJob(j, message)
+	})
 	if err != nil {
-		log.Errorf("Error finding job during containers update %d: %s", job.ID, err)
 		return err
 	}
-	job.Containers = append(job.Containers, container)
-	j.Containers = append(j.Containers, container)
-	err = ju.jobStore.Update(j)
+	ju.publish(job, JobEvent{Type: JobEventRequeued, Message: message})
+	return nil
+}
+
+func (ju jobUpdater) StartAttempt(job *Job, message string) error {
+	err := ju.modify(job, "attempts", func(j *Job) {
+		j.Attempts = append(j.Attempts, currentAttempt(*j))
+		resetJob(j, message)
+	})
 	if err != nil {
-		log.Errorf("Error updating job containers %d: %s", job.ID, err)
 		return err
 	}
+	ju.publish(job, JobEvent{Type: JobEventAttempt, Message: message})
 	return nil
 }
+
+// modify makes the change to the stored job and saves it, then
+// replaces the caller's copy with the stored one so they stay
+// the same. Only the change is made, whatever else the caller
+// may have changed in its copy isn't saved.
+func (ju jobUpdater) modify(job *Job, what string, change func(j *Job)) error {
+	j, err := ju.jobStore.Find(job.ID)
+	if err != nil {
+		log.Errorf("Error finding job %d during %s update: %s", job.ID, what, err)
+		return err
+	}
+	change(&j)
+	if err := ju.jobStore.Update(j); err != nil {
+		log.Errorf("Error updating %s of job %d: %s", what, job.ID, err)
+		return err
+	}
+	*job = j
+	return nil
+}
+
+// publish lets subscribers know about an update made to the job
+func (ju jobUpdater) publish(job *Job, event JobEvent) {
+	event.JobID = job.ID
+	event.Status = job.Status
+	event.Time = time.Now()
+	ju.eventBus.Publish(event)
+}
+
+// currentAttempt records what happened during the latest run of a job
+func currentAttempt(job Job) Attempt {
+	return Attempt{
+		Status:     job.Status,
+		Message:    job.Message,
+		Results:    job.Results,
+		Containers: job.Containers,
+		Images:     job.Images,
+		StartTime:  job.StartTime,
+		EndTime:    job.EndTime,
+		Provenance: job.Provenance,
+	}
+}
+
+// resetJob clears everything recorded while running a job
+// and puts it back in the queued state
+func resetJob(job *Job, message string) {
+	job.Status = JobStatusQueued
+	job.Message = message
+	job.Results = nil
+	job.Containers = nil
+	job.Images = nil
+	job.StartTime = time.Time{}
+	job.EndTime = time.Time{}
+	job.Provenance = Provenance{}
+}
@@ -1,6 +1,7 @@
 package dockworker
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -8,48 +9,118 @@ import (
 	"github.com/fsouza/go-dockerclient"
 )
 
-func (jm jobManager) jobWorker(job Job) {
-	log.Debugf("Running job %+v", job)
-	jr, err := newJobRunner(&job, jm.client, jm.eventListner, jm.jobUpdater, jm.stopEventListener)
-	if err != nil {
-		log.Errorf("Error creating job runner: %s", err)
-		jm.jobUpdater.UpdateStatus(&job, JobStatusFailed)
-		return
-	}
+const (
+	// how long to wait for the logs of a container to be
+	// archived after it exits before carrying on with the job
+	logArchiveTimeout = 10 * time.Second
+)
+
+func (jm *jobManager) newJobRunner(job Job) (*jobRunner, error) {
+	return newJobRunner(&job, jm.client, jm.eventListner, jm.jobUpdater, jm.logArchiver, jm.artifactStore, jm.blobStore, jm.registryAuth)
+}
+
+func (jm *jobManager) jobWorker(jr *jobRunner) {
+	defer jm.jobFinished(jr.job.ID)
+	log.Debugf("Running job %+v", jr.job)
 	jr.runJob()
-	go SendWebhook(*jr.job)
+	jm.finishJob(jr)
+}
+
+// resumeWorker takes over a job whose command was
+// running in the given container before a restart
+func (jm *jobManager) resumeWorker(jr *jobRunner, container *docker.Container) {
+	defer jm.jobFinished(jr.job.ID)
+	log.Debugf("Resuming job %+v", jr.job)
+	jr.resumeJob(container)
+	jm.finishJob(jr)
+}
+
+// finishJob retries the job for as long as its retry
+// policy allows, then sends the webhook for the outcome
+func (jm *jobManager) finishJob(jr *jobRunner) {
+	for jr.job.Retry != nil && !jm.Draining() {
+		delay, ok := jr.job.Retry.nextRetry(*jr.job)
+		if !ok {
+			break
+		}
+		if jr.job.Retry.WebhookEachAttempt {
+			jm.webhookSender.Send(*jr.job)
+		}
+
+		// each attempt gets a fresh runner, since
+		// the old one has stopped listening for events
+		next, err := jm.newJobRunner(*jr.job)
+		if err != nil {
+			log.Errorf("Error creating job runner: %s", err)
+			break
+		}
+		jm.lock.Lock()
+		// a stop which came in after the last attempt
+		// ended applies to the next one instead
+		select {
+		case req := <-jr.abortChan:
+			next.abortChan <- req
+		default:
+		}
+		jm.running[jr.job.ID] = next
+		jm.lock.Unlock()
+		jr = next
+		jr.retryJob(delay)
+	}
+	jm.cleanupJob(jr.job)
+	jm.webhookSender.Send(*jr.job)
+}
+
+// abortRequest asks a job runner to end the job early
+type abortRequest struct {
+	status  JobStatus
+	message string
 }
 
 type jobRunner struct {
-	client            *docker.Client
+	client            Runtime
 	eventListener     DockerEventListener
-	stopEventListener StopEventListener
 	eventChan         chan *docker.APIEvents
-	stopChan          chan JobID
+	abortChan         chan abortRequest
 	cmdChan           chan interface{}
 	cmdIndex          int
 	prevImage         *docker.Image
 	currContainer     *docker.Container
+	currContainerDone bool
+	jobTimeout        <-chan time.Time
+	cmdTimeout        <-chan time.Time
 	job               *Job
 	jobUpdater        JobUpdater
+	logArchiver       *logArchiver
+	logsArchived      <-chan bool
+	artifactStore     ArtifactStore
+	blobStore         BlobStore
+	registryAuth      RegistryAuth
+	// baseImageConfig is the config of the job's image,
+	// looked up when a command first needs it
+	baseImageConfig *docker.Config
+	// resumed is whether the current container was running before
+	// a restart, so its result and image may already be recorded
+	resumed bool
 }
 
-func newJobRunner(job *Job, client *docker.Client, eventListener DockerEventListener, jobUpdater JobUpdater, stopEventListener StopEventListener) (*jobRunner, error) {
+func newJobRunner(job *Job, client Runtime, eventListener DockerEventListener, jobUpdater JobUpdater, logArchiver *logArchiver, artifactStore ArtifactStore, blobStore BlobStore, registryAuth RegistryAuth) (*jobRunner, error) {
 	jr := &jobRunner{
-		client:            client,
-		job:               job,
-		eventListener:     eventListener,
-		jobUpdater:        jobUpdater,
-		stopEventListener: stopEventListener,
+		client:        client,
+		job:           job,
+		eventListener: eventListener,
+		jobUpdater:    jobUpdater,
+		logArchiver:   logArchiver,
+		artifactStore: artifactStore,
+		blobStore:     blobStore,
+		registryAuth:  registryAuth,
 	}
 
 	// register an event listener
 	jr.eventChan = make(chan *docker.APIEvents)
 	jr.eventListener.RegisterListener(jr.eventChan)
-	jr.stopChan = make(chan JobID)
-	jr.stopEventListener.RegisterListener(jr.stopChan)
+	jr.abortChan = make(chan abortRequest, 1)
 	jr.cmdChan = make(chan interface{}, 2)
-	jr.cmdChan <- true
 	jr.cmdIndex = 0
 	jr.prevImage = &docker.Image{
 		ID: job.ImageName,
@@ -63,8 +134,91 @@ func (jr *jobRunner) runJob() error {
 	// TODO: explicitly handle job update statuses?
 	// maybe just fail the job?
 	jr.jobUpdater.UpdateStatus(jr.job, JobStatusRunning)
+	jr.startJobTimeout(time.Now())
+
+	if err := jr.resolveImage(); err != nil {
+		return err
+	}
+	jr.cmdChan <- true
+	return jr.handleEvents()
+}
+
+// retryJob waits for the delay then runs the job again,
+// keeping the record of the attempt before
+func (jr *jobRunner) retryJob(delay time.Duration) error {
+	attempt := len(jr.job.Attempts) + 1
+	log.Infof("Retrying job %d in %s after attempt %d was %s", jr.job.ID, delay, attempt, jr.job.Status)
+	jr.jobUpdater.StartAttempt(jr.job, fmt.Sprintf("Retrying in %s after attempt %d was %s", delay, attempt, jr.job.Status))
+
+	backoff := time.After(delay)
+	for {
+		select {
+		case <-backoff:
+			return jr.runJob()
+		case req := <-jr.abortChan:
+			log.Infof("Job %d aborted while waiting to retry", jr.job.ID)
+			if req.message != "" {
+				jr.jobUpdater.UpdateMessage(jr.job, req.message)
+			}
+			jr.jobUpdater.UpdateStatus(jr.job, req.status)
+			jr.cleanup()
+			return nil
+		}
+	}
+}
 
-	jr.pullImage()
+// resumeJob picks up a job after a restart by treating the
+// given container as the one running the current command
+func (jr *jobRunner) resumeJob(container *docker.Container) error {
+	defer jr.cleanup()
+	jr.cmdIndex = len(jr.job.Containers) - 1
+	if jr.cmdIndex > 0 {
+		jr.prevImage = &docker.Image{
+			ID: string(jr.job.Images[jr.cmdIndex-1]),
+		}
+	}
+	jr.currContainer = container
+	jr.resumed = true
+	jr.archiveLogs()
+	// the job only gets what's left of its timeout, so
+	// restarts can't keep it running for ever
+	startedAt := jr.job.StartTime
+	if startedAt.IsZero() {
+		startedAt = container.State.StartedAt
+	}
+	if startedAt.IsZero() {
+		startedAt = time.Now()
+	}
+	jr.startJobTimeout(startedAt)
+
+	// the container may have died before we started listening
+	// for its events, so check on it now that we are
+	c, err := jr.client.InspectContainer(container.ID)
+	if err != nil {
+		log.Errorf("Error inspecting container %s: %s", container.ID, err)
+		jr.jobUpdater.UpdateStatus(jr.job, JobStatusError)
+		return err
+	}
+	if c.State.Running {
+		log.Infof("Re-attached to running container %s of job %d", c.ID, jr.job.ID)
+		jr.startCmdTimeout(c.State.StartedAt)
+	} else {
+		log.Infof("Container %s of job %d exited while we were down", c.ID, jr.job.ID)
+		err := jr.handleDieEvent(&docker.APIEvents{
+			ID:     c.ID,
+			Status: "die",
+			Time:   c.State.FinishedAt.Unix(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return jr.handleEvents()
+}
+
+// handleEvents runs the job's commands one after the other
+// until there are none left or the job ends early
+func (jr *jobRunner) handleEvents() error {
 	for {
 		select {
 		// TODO: think about how to filter these events
@@ -90,39 +244,125 @@ func (jr *jobRunner) runJob() error {
 				log.Errorf("Error running command %s", err)
 				return err
 			}
-		case ID := <-jr.stopChan:
-			log.Debugf("Received stop event")
-			jr.handleStopRequest(ID)
+		case req := <-jr.abortChan:
+			log.Debugf("Received abort request")
+			jr.stop(req.status, req.message)
+		case <-jr.jobTimeout:
+			jr.jobTimeout = nil
+			jr.stop(JobStatusTimedOut, fmt.Sprintf("Job exceeded its timeout of %d seconds", jr.job.Timeout))
+		case <-jr.cmdTimeout:
+			jr.cmdTimeout = nil
+			jr.stop(JobStatusTimedOut, fmt.Sprintf("Command %d exceeded its timeout of %d seconds",
+				jr.cmdIndex, jr.job.Cmds[jr.cmdIndex].Timeout))
 		}
 	}
 }
 
-func (jr *jobRunner) handleStopRequest(jobID JobID) {
-	if jr.job.ID != jobID {
+// startJobTimeout starts counting down the time the
+// whole job may run for, from when the job started
+func (jr *jobRunner) startJobTimeout(startedAt time.Time) {
+	if jr.job.Timeout > 0 {
+		jr.jobTimeout = time.After(startedAt.Add(time.Duration(jr.job.Timeout) * time.Second).Sub(time.Now()))
+	}
+}
+
+// startCmdTimeout starts counting down the time the current
+// command may run for, from when its container started
+func (jr *jobRunner) startCmdTimeout(startedAt time.Time) {
+	timeout := jr.job.Cmds[jr.cmdIndex].Timeout
+	if timeout > 0 {
+		jr.cmdTimeout = time.After(startedAt.Add(time.Duration(timeout) * time.Second).Sub(time.Now()))
+	}
+}
+
+// ended returns whether the job already has its final status,
+// for example because it was stopped, in which case the rest
+// of its commands shouldn't run
+func (jr *jobRunner) ended() bool {
+	return jr.job.Status != JobStatusRunning
+}
+
+// abort asks the runner to stop the job, ending it with the given
+// status and message. It never blocks, only the first request counts.
+func (jr *jobRunner) abort(status JobStatus, message string) {
+	select {
+	case jr.abortChan <- abortRequest{status: status, message: message}:
+	default:
+	}
+}
+
+// stop stops the current container and ends the job with the given status.
+// If no command is running the remaining commands are skipped.
+func (jr *jobRunner) stop(status JobStatus, message string) {
+	if jr.ended() {
+		log.Debugf("Job %d has already been %s", jr.job.ID, jr.job.Status)
 		return
 	}
 	log.Infof("Stoppping job %d", jr.job.ID)
-	if err := jr.client.StopContainer(jr.currContainer.ID, 5); err != nil {
-		log.Errorf("Error stoppping job %d: %s", jr.job.ID, err)
+	if jr.currContainer != nil && !jr.currContainerDone {
+		if err := jr.client.StopContainer(jr.currContainer.ID, 5); err != nil {
+			log.Errorf("Error stoppping job %d: %s", jr.job.ID, err)
+		}
+	}
+	if message != "" {
+		jr.jobUpdater.UpdateMessage(jr.job, message)
 	}
-	log.Debugf("Setting status stopped for job %d", jobID)
-	jr.jobUpdater.UpdateStatus(jr.job, JobStatusStopped)
+	log.Debugf("Setting status %s for job %d", status, jr.job.ID)
+	jr.jobUpdater.UpdateStatus(jr.job, status)
 }
 
 func (jr *jobRunner) pullImage() error {
-	repo, tag := docker.ParseRepositoryTag(jr.job.ImageName)
+	repo, tag, digest := splitImageName(jr.job.ImageName)
+	if digest != "" {
+		// the daemon pulls by digest when it's given as the tag
+		tag = digest
+	}
 	opts := docker.PullImageOptions{
 		Repository: repo,
 		Tag:        tag,
 	}
-	log.Debugf("Pulling image %s", jr.job.ImageName)
-	if err := jr.client.PullImage(opts, docker.AuthConfiguration{}); err != nil {
-		log.Errorf("Error pulling image %s: %s", jr.job.ImageName, err)
+	auth, err := jr.registryAuth.ForImage(jr.job.ImageName, jr.job.RegistryCredentials)
+	if err != nil {
+		log.Errorf("Error getting registry credentials for image %s: %s", jr.job.ImageName, err)
+		jr.jobUpdater.UpdateMessage(jr.job, err.Error())
 		jr.jobUpdater.UpdateStatus(jr.job, JobStatusError)
 		return err
 	}
-	log.Debugf("Done pulling image %d", jr.job.ImageName)
-	return nil
+	log.Debugf("Pulling image %s", jr.job.ImageName)
+	pulled := make(chan error, 1)
+	go func() {
+		pulled <- jr.client.PullImage(opts, auth)
+	}()
+
+	for {
+		select {
+		case err := <-pulled:
+			if err != nil {
+				log.Errorf("Error pulling image %s: %s", jr.job.ImageName, err)
+				jr.jobUpdater.UpdateStatus(jr.job, JobStatusError)
+				return err
+			}
+			log.Debugf("Done pulling image %s", jr.job.ImageName)
+			return nil
+		case <-jr.jobTimeout:
+			return jr.abortPull(JobStatusTimedOut,
+				fmt.Sprintf("Job exceeded its timeout of %d seconds while pulling image %s", jr.job.Timeout, jr.job.ImageName))
+		case req := <-jr.abortChan:
+			if req.message == "" {
+				req.message = fmt.Sprintf("Stopped while pulling image %s", jr.job.ImageName)
+			}
+			return jr.abortPull(req.status, req.message)
+		}
+	}
+}
+
+// abortPull ends the job while its image is being pulled. The pull
+// carries on in the background since there's no way to cancel it.
+func (jr *jobRunner) abortPull(status JobStatus, message string) error {
+	log.Infof("Job %d ended while pulling image: %s", jr.job.ID, message)
+	jr.jobUpdater.UpdateMessage(jr.job, message)
+	jr.jobUpdater.UpdateStatus(jr.job, status)
+	return errors.New(message)
 }
 
 func (jr *jobRunner) cleanup() {
@@ -136,19 +376,6 @@ func (jr *jobRunner) cleanup() {
 		}
 		// log.Debugf("Done flushing Docker event channel")
 	}()
-	go func() {
-		// read the events out of this channel
-		// to ensure no goroutines leak while
-		// trying to send on the channel
-		for _ = range jr.eventChan {
-			// TODO: this never stops flushing...
-			// log.Debugf("Flushing stop event channel")
-		}
-		// log.Debugf("Done flushing stop event channel")
-	}()
-	log.Debugf("Removing event stop listener")
-	jr.stopEventListener.UnregisterListener(jr.stopChan)
-	log.Debugf("Stop event listener removed")
 }
 
 func (jr *jobRunner) handleEvent(event *docker.APIEvents) error {
@@ -180,6 +407,10 @@ func (jr *jobRunner) handleEvent(event *docker.APIEvents) error {
 
 	case "die":
 		log.Debugf("Received die status for %s", event.ID)
+		if jr.currContainerDone {
+			// we already handled this container dying
+			return nil
+		}
 		if err := jr.handleDieEvent(event); err != nil {
 			return err
 		}
@@ -200,23 +431,35 @@ func (jr *jobRunner) handleEvent(event *docker.APIEvents) error {
 }
 
 func (jr *jobRunner) handleStartEvent(event *docker.APIEvents) {
-	jr.jobUpdater.UpdateStartTime(jr.job, time.Unix(event.Time, 0))
+	// the job started with its first container
+	if jr.job.StartTime.IsZero() {
+		jr.jobUpdater.UpdateStartTime(jr.job, time.Unix(event.Time, 0))
+	}
 }
 
 func (jr *jobRunner) handleDieEvent(event *docker.APIEvents) error {
 	// the container died, let's see what it returned
+	jr.currContainerDone = true
+	jr.cmdTimeout = nil
+	jr.waitForArchivedLogs()
 	jr.jobUpdater.UpdateEndTime(jr.job, time.Unix(event.Time, 0))
 	exitCode, err := jr.client.WaitContainer(jr.currContainer.ID)
 	if err != nil {
 		log.Errorf("Error waiting for container: %s", err)
 		return err
 	}
-	jr.jobUpdater.AddCmdResult(jr.job, CmdResult(exitCode))
+	resumed := jr.resumed
+	jr.resumed = false
+	if !resumed || len(jr.job.Results) <= jr.cmdIndex {
+		jr.jobUpdater.AddCmdResult(jr.job, CmdResult(exitCode))
+	}
 	if exitCode != 0 {
 		log.Infof("Container %s exited with non-success code %d", jr.currContainer.ID, exitCode)
-		if jr.job.Status != JobStatusStopped {
+		jr.collectArtifacts()
+		if !jr.ended() {
 			// non-zero exit codes only apply to jobs which
 			// haven't been forcibly stopped
+			jr.reportOOM()
 			log.Debugf("Setting status failed for job %d", jr.job.ID)
 			jr.jobUpdater.UpdateStatus(jr.job, JobStatusFailed)
 		}
@@ -224,6 +467,16 @@ func (jr *jobRunner) handleDieEvent(event *docker.APIEvents) error {
 		return nil
 	}
 
+	if resumed && len(jr.job.Images) > jr.cmdIndex {
+		// the image was committed before a restart
+		jr.prevImage = &docker.Image{
+			ID: string(jr.job.Images[jr.cmdIndex]),
+		}
+		jr.cmdIndex++
+		jr.cmdChan <- true
+		return nil
+	}
+
 	image, err := jr.client.CommitContainer(docker.CommitContainerOptions{
 		Container: jr.currContainer.ID,
 	})
@@ -240,21 +493,46 @@ func (jr *jobRunner) handleDieEvent(event *docker.APIEvents) error {
 }
 
 func (jr *jobRunner) runNextCmd() error {
+	if jr.ended() {
+		// the job was ended while between commands
+		log.Infof("Not running remaining commands of job %d, it is %s", jr.job.ID, jr.job.Status)
+		close(jr.cmdChan)
+		return nil
+	}
 	// TODO: handle jobs with no explicit commands
 	if jr.cmdIndex >= len(jr.job.Cmds) {
 		log.Infof("Done running job %d", jr.job.ID)
+		jr.collectArtifacts()
 		jr.jobUpdater.UpdateStatus(jr.job, JobStatusSuccessful)
 		close(jr.cmdChan)
 		return nil
 	}
 	config := docker.Config{
-		Cmd:   jr.job.Cmds[jr.cmdIndex],
-		Image: jr.prevImage.ID,
-		Env:   convertEnv(jr.job.Env),
+		Cmd:    jr.job.Cmds[jr.cmdIndex].Args,
+		Image:  jr.prevImage.ID,
+		Env:    convertEnv(jr.job.Env),
+		Labels: containerLabels(jr.job.ID, jr.cmdIndex),
+	}
+	if hasCmdOptions(*jr.job) {
+		image, err := jr.imageConfig()
+		if err != nil {
+			log.Warnf("Failed to inspect image %s: %s", jr.job.ImageName, err)
+			jr.jobUpdater.UpdateStatus(jr.job, JobStatusError)
+			close(jr.cmdChan)
+			return err
+		}
+		resolveCmdOptions(*jr.job, jr.cmdIndex, image).apply(&config)
 	}
 
+	hostConfig := &docker.HostConfig{}
+	if jr.job.Resources != nil {
+		jr.job.Resources.apply(hostConfig)
+	}
+	applyMounts(*jr.job, hostConfig)
+	applyNetwork(*jr.job, hostConfig)
 	createOpts := docker.CreateContainerOptions{
-		Config: &config,
+		Config:     &config,
+		HostConfig: hostConfig,
 	}
 
 	container, err := jr.client.CreateContainer(createOpts)
@@ -270,8 +548,19 @@ func (jr *jobRunner) runNextCmd() error {
 
 	log.Debugf("%+v", container)
 
-	hostConfig := &docker.HostConfig{}
-	err = jr.client.StartContainer(container.ID, hostConfig)
+	if jr.cmdIndex == 0 && len(jr.job.Inputs) > 0 {
+		// later commands get the inputs from the committed images
+		if err := uploadInputs(jr.client, jr.blobStore, *jr.job, container.ID); err != nil {
+			log.Warnf("Failed to upload inputs of job %d: %s", jr.job.ID, err)
+			jr.jobUpdater.UpdateMessage(jr.job, err.Error())
+			jr.jobUpdater.UpdateStatus(jr.job, JobStatusError)
+			close(jr.cmdChan)
+			return err
+		}
+	}
+
+	// the host config was given when creating the container
+	err = jr.client.StartContainer(container.ID, nil)
 	if err != nil {
 		log.Warnf("Failed to start container: %s", err)
 		jr.jobUpdater.UpdateStatus(jr.job, JobStatusError)
@@ -279,9 +568,81 @@ func (jr *jobRunner) runNextCmd() error {
 		return err
 	}
 	jr.currContainer = container
+	jr.currContainerDone = false
+	jr.archiveLogs()
+	jr.startCmdTimeout(time.Now())
 	return nil
 }
 
+// reportOOM sets the message of the job if the
+// kernel killed the current command for using
+// more memory than the container could have
+func (jr *jobRunner) reportOOM() {
+	c, err := jr.client.InspectContainer(jr.currContainer.ID)
+	if err != nil {
+		log.Errorf("Error inspecting container %s: %s", jr.currContainer.ID, err)
+		return
+	}
+	if !c.State.OOMKilled {
+		return
+	}
+	message := fmt.Sprintf("Command %d was killed for running out of memory", jr.cmdIndex)
+	if jr.job.Resources != nil && jr.job.Resources.Memory > 0 {
+		message += fmt.Sprintf(", its limit is %d bytes", jr.job.Resources.Memory)
+	}
+	log.Infof("Job %d: %s", jr.job.ID, message)
+	jr.jobUpdater.UpdateMessage(jr.job, message)
+}
+
+// imageConfig returns the config of the image the job started from,
+// which options the commands don't set are taken from
+func (jr *jobRunner) imageConfig() (*docker.Config, error) {
+	if jr.baseImageConfig != nil {
+		return jr.baseImageConfig, nil
+	}
+	image, err := jr.client.InspectImage(jr.job.ImageName)
+	if err != nil {
+		return nil, err
+	}
+	jr.baseImageConfig = image.Config
+	if jr.baseImageConfig == nil {
+		jr.baseImageConfig = &docker.Config{}
+	}
+	return jr.baseImageConfig, nil
+}
+
+// collectArtifacts copies the artifacts out of the last container,
+// which is the current one, once there are no more commands to run
+func (jr *jobRunner) collectArtifacts() {
+	if jr.artifactStore == nil || len(jr.job.Artifacts) == 0 || jr.currContainer == nil {
+		return
+	}
+	log.Debugf("Collecting artifacts of job %d", jr.job.ID)
+	collectArtifacts(jr.client, jr.artifactStore, *jr.job, jr.currContainer.ID)
+}
+
+// archiveLogs starts archiving the logs of the current container
+func (jr *jobRunner) archiveLogs() {
+	if jr.logArchiver == nil {
+		return
+	}
+	jr.logsArchived = jr.logArchiver.capture(jr.job.ID, Container(jr.currContainer.ID), jr.cmdIndex)
+}
+
+// waitForArchivedLogs gives the logs of a container which exited the
+// chance to be archived, so they're all there once the job is done
+func (jr *jobRunner) waitForArchivedLogs() {
+	if jr.logsArchived == nil {
+		return
+	}
+	select {
+	case <-jr.logsArchived:
+	case <-time.After(logArchiveTimeout):
+		log.Warnf("Gave up waiting for the logs of container %s to be archived", jr.currContainer.ID)
+	}
+	jr.logsArchived = nil
+}
+
 func convertEnv(env map[string]string) []string {
 	var converted []string
 	for k, v := range env {
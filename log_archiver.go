@@ -0,0 +1,120 @@
+package dockworker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// logArchiver copies the output of job containers into a LogStore as they run
+type logArchiver struct {
+	store  LogStore
+	client Runtime
+	// maxBytes is the most stored for a job, zero or less means no limit
+	maxBytes int64
+}
+
+func newLogArchiver(store LogStore, client Runtime, maxBytes int64) *logArchiver {
+	return &logArchiver{
+		store:    store,
+		client:   client,
+		maxBytes: maxBytes,
+	}
+}
+
+// capture archives the logs of the container running the
+// command at cmdIndex, closing the returned channel once
+// the container has exited and its logs are stored
+func (a *logArchiver) capture(ID JobID, container Container, cmdIndex int) <-chan bool {
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		if err := a.archive(ID, container, cmdIndex); err != nil {
+			log.Errorf("Error archiving logs of container %s of job %d: %s", container, ID, err)
+		}
+	}()
+	return done
+}
+
+func (a *logArchiver) archive(ID JobID, container Container, cmdIndex int) error {
+	// create first, since a resumed container starts its logs over
+	w, err := a.store.Create(ID, container)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	size, err := a.store.Size(ID)
+	if err != nil {
+		return err
+	}
+
+	sink := &cappedLogSink{
+		writer:    w,
+		maxBytes:  a.maxBytes,
+		remaining: a.maxBytes - size,
+	}
+	stdout := &logLineWriter{cmd: cmdIndex, stream: LogStreamStdout, emit: sink.write}
+	stderr := &logLineWriter{cmd: cmdIndex, stream: LogStreamStderr, emit: sink.write}
+	err = a.client.Logs(docker.LogsOptions{
+		Container:    string(container),
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+		Follow:       true,
+		Stdout:       true,
+		Stderr:       true,
+		Timestamps:   true,
+	})
+	if err != nil {
+		return err
+	}
+	if err := stdout.Flush(); err != nil {
+		return err
+	}
+	return stderr.Flush()
+}
+
+// cappedLogSink stores lines until the job has used up its share
+// of the store, then stores a line saying the logs were cut short
+type cappedLogSink struct {
+	writer    io.Writer
+	maxBytes  int64
+	remaining int64
+	truncated bool
+}
+
+func (sink *cappedLogSink) write(line LogLine) error {
+	if sink.truncated {
+		return nil
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if sink.maxBytes > 0 {
+		if int64(len(data)) > sink.remaining {
+			sink.truncated = true
+			return sink.writeMarker(line)
+		}
+		sink.remaining -= int64(len(data))
+	}
+	_, err = sink.writer.Write(data)
+	return err
+}
+
+func (sink *cappedLogSink) writeMarker(line LogLine) error {
+	data, err := json.Marshal(LogLine{
+		Cmd:    line.Cmd,
+		Stream: LogStreamDockworker,
+		Time:   line.Time,
+		Line:   fmt.Sprintf("Logs truncated, the job wrote more than the limit of %d bytes", sink.maxBytes),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = sink.writer.Write(append(data, '\n'))
+	return err
+}
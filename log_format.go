@@ -0,0 +1,173 @@
+package dockworker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// LogFormat is the format logs are written in
+type LogFormat string
+
+const (
+	// LogFormatText writes the output of the commands as it is
+	LogFormatText LogFormat = "text"
+	// LogFormatNDJSON writes a LogLine as JSON for every line of output
+	LogFormatNDJSON LogFormat = "ndjson"
+
+	// MIMENDJSON is the content type of logs in the NDJSON format
+	MIMENDJSON = "application/x-ndjson"
+
+	// maxLogLineBytes is the longest line held on to, longer
+	// ones are passed on in pieces of at most this many bytes
+	maxLogLineBytes = 64 * 1024
+)
+
+// LogStream is the output stream a line of logs was written to
+type LogStream string
+
+const (
+	// LogStreamStdout is the standard output of a command
+	LogStreamStdout LogStream = "stdout"
+	// LogStreamStderr is the standard error of a command
+	LogStreamStderr LogStream = "stderr"
+	// LogStreamDockworker is for notes dockworker adds to
+	// the logs, like when they were cut short
+	LogStreamDockworker LogStream = "dockworker"
+)
+
+// LogLine is a line of output of a command
+type LogLine struct {
+	// Cmd is the index of the command which wrote the line
+	Cmd    int       `json:"cmd"`
+	Stream LogStream `json:"stream"`
+	// Time is when Docker received the line
+	Time time.Time `json:"time"`
+	Line string    `json:"line"`
+	// Partial is set when the line didn't end with a newline,
+	// because it was too long or the output ended without one
+	Partial bool `json:"partial,omitempty"`
+}
+
+func parseLogFormat(v string) (LogFormat, error) {
+	switch LogFormat(v) {
+	case "", LogFormatText:
+		return LogFormatText, nil
+	case LogFormatNDJSON:
+		return LogFormatNDJSON, nil
+	}
+	return "", fmt.Errorf("Invalid log format %q", v)
+}
+
+// contentType returns the content type of logs in the format
+func (format LogFormat) contentType() string {
+	if format == LogFormatNDJSON {
+		return MIMENDJSON
+	}
+	return "text/plain"
+}
+
+// logLineEmitter returns a func which writes lines in the format
+func logLineEmitter(format LogFormat, output io.Writer) func(line LogLine) error {
+	if format == LogFormatNDJSON {
+		encoder := json.NewEncoder(output)
+		return func(line LogLine) error {
+			return encoder.Encode(line)
+		}
+	}
+	return func(line LogLine) error {
+		text := line.Line
+		if !line.Partial {
+			text += "\n"
+		}
+		_, err := io.WriteString(output, text)
+		return err
+	}
+}
+
+// nextLogLine returns the length of the first line in the output and
+// whether it's complete. An unfinished line is only returned once it's
+// as long as a line can be, cut so it doesn't split a character.
+func nextLogLine(output []byte) (int, bool, bool) {
+	i := bytes.IndexByte(output, '\n')
+	if i >= 0 && i <= maxLogLineBytes {
+		return i, true, true
+	}
+	if len(output) <= maxLogLineBytes {
+		return 0, false, false
+	}
+	n := maxLogLineBytes
+	for j := 0; j < utf8.UTFMax && n > 0 && !utf8.RuneStart(output[n]); j++ {
+		n--
+	}
+	if !utf8.RuneStart(output[n]) {
+		n = maxLogLineBytes
+	}
+	return n, false, true
+}
+
+// logLineWriter splits the timestamped output of a
+// stream into lines, passing each one on as a LogLine
+type logLineWriter struct {
+	cmd     int
+	stream  LogStream
+	emit    func(line LogLine) error
+	partial []byte
+	// continued is whether the start of the current line was
+	// already passed on, at the time the line was received
+	continued bool
+	lineTime  time.Time
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		n, complete, ok := nextLogLine(w.partial)
+		if !ok {
+			return len(p), nil
+		}
+		if err := w.writeLine(w.partial[:n], !complete); err != nil {
+			return 0, err
+		}
+		if complete {
+			n++
+		}
+		w.partial = w.partial[n:]
+	}
+}
+
+// Flush writes what's left of an unfinished last line
+func (w *logLineWriter) Flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+	err := w.writeLine(w.partial, true)
+	w.partial = nil
+	return err
+}
+
+func (w *logLineWriter) writeLine(line []byte, partial bool) error {
+	logLine := LogLine{
+		Cmd:     w.cmd,
+		Stream:  w.stream,
+		Line:    string(line),
+		Partial: partial,
+	}
+	if w.continued {
+		logLine.Time = w.lineTime
+	} else {
+		// Docker starts every line with its timestamp and a space
+		if i := bytes.IndexByte(line, ' '); i > 0 {
+			if t, err := time.Parse(time.RFC3339Nano, string(line[:i])); err == nil {
+				logLine.Time = t
+				logLine.Line = string(line[i+1:])
+			}
+		}
+		w.lineTime = logLine.Time
+	}
+	w.continued = partial
+	return w.emit(logLine)
+}
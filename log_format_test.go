@@ -0,0 +1,90 @@
+package dockworker
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogLineWriter(t *testing.T) {
+	output := &bytes.Buffer{}
+	w := &logLineWriter{cmd: 2, stream: LogStreamStderr, emit: logLineEmitter(LogFormatNDJSON, output)}
+	w.Write([]byte("2016-06-01T12:00:00.5Z first li"))
+	w.Write([]byte("ne\n2016-06-01T12:00:01Z second line\nno timestamp"))
+	assert.NoError(t, w.Flush())
+
+	decoder := json.NewDecoder(output)
+	lines := []LogLine{}
+	for decoder.More() {
+		line := LogLine{}
+		assert.NoError(t, decoder.Decode(&line))
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []LogLine{
+		{Cmd: 2, Stream: LogStreamStderr, Time: time.Date(2016, 6, 1, 12, 0, 0, 500000000, time.UTC), Line: "first line"},
+		{Cmd: 2, Stream: LogStreamStderr, Time: time.Date(2016, 6, 1, 12, 0, 1, 0, time.UTC), Line: "second line"},
+		{Cmd: 2, Stream: LogStreamStderr, Line: "no timestamp", Partial: true},
+	}, lines)
+}
+
+func TestLogLineWriterLongLines(t *testing.T) {
+	lines := []LogLine{}
+	w := &logLineWriter{stream: LogStreamStdout, emit: func(line LogLine) error {
+		lines = append(lines, line)
+		return nil
+	}}
+	long := strings.Repeat("a", maxLogLineBytes+10)
+	w.Write([]byte("2016-06-01T12:00:00Z " + long[:10]))
+	w.Write([]byte(long[10:] + "\n2016-06-01T12:00:01Z next"))
+	assert.True(t, len(w.partial) < maxLogLineBytes, "Long lines shouldn't be held on to")
+	assert.NoError(t, w.Flush())
+
+	if assert.Equal(t, 3, len(lines)) {
+		assert.True(t, lines[0].Partial, "The start of a long line should be partial")
+		assert.False(t, lines[1].Partial)
+		assert.Equal(t, long, lines[0].Line+lines[1].Line)
+		assert.Equal(t, lines[0].Time, lines[1].Time, "Every piece of a line should have its time")
+		assert.Equal(t, "next", lines[2].Line)
+	}
+
+	output := &bytes.Buffer{}
+	emit := logLineEmitter(LogFormatText, output)
+	for _, line := range lines {
+		emit(line)
+	}
+	assert.Equal(t, long+"\nnext", output.String(), "Text logs should match the output")
+
+	// pieces of a line shouldn't split a character
+	lines = []LogLine{}
+	w = &logLineWriter{stream: LogStreamStdout, emit: func(line LogLine) error {
+		lines = append(lines, line)
+		return nil
+	}}
+	accented := "a" + strings.Repeat("é", maxLogLineBytes)
+	w.Write([]byte(accented))
+	w.Flush()
+	text := ""
+	for _, line := range lines {
+		assert.True(t, utf8.ValidString(line.Line), "Pieces should be valid UTF-8")
+		text += line.Line
+	}
+	assert.Equal(t, accented, text)
+}
+
+func TestParseLogFormat(t *testing.T) {
+	format, err := parseLogFormat("")
+	assert.NoError(t, err)
+	assert.Equal(t, LogFormatText, format, "Format should default to text")
+
+	format, err = parseLogFormat("ndjson")
+	assert.NoError(t, err)
+	assert.Equal(t, LogFormatNDJSON, format)
+
+	_, err = parseLogFormat("xml")
+	assert.Error(t, err)
+}
@@ -1,43 +1,242 @@
 package dockworker
 
 import (
+	"encoding/json"
 	"io"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/fsouza/go-dockerclient"
 )
 
-// LogService handles retrieving logs from containers
+const (
+	// how often to check if a container being followed has started
+	containerStartPollInterval = 250 * time.Millisecond
+)
+
+// LogService handles retrieving logs of jobs, from
+// the LogStore if they're archived or else from Docker
 type LogService interface {
-	GetLogs(job Job, output io.Writer) error
+	GetLogs(job Job, format LogFormat, output io.Writer) error
+	// GetCmdLogs gets the logs of the command at the index,
+	// which are empty if the command hasn't run yet
+	GetCmdLogs(job Job, index int, format LogFormat, output io.Writer) error
+	// FollowLogs streams the logs of every command of the job as they're
+	// written, until the job is done or closed receives a value
+	FollowLogs(ID JobID, format LogFormat, output io.Writer, closed <-chan bool) error
 }
 
-// NewLogService returns a new LogService
-func NewLogService(jobStore JobStore, client *docker.Client) LogService {
+// NewLogService returns a new LogService. The logStore
+// is nil if logs aren't archived.
+func NewLogService(jobStore JobStore, logStore LogStore, client Runtime, eventBus JobEventBus) LogService {
 	return logService{
 		jobStore: jobStore,
+		logStore: logStore,
 		client:   client,
+		eventBus: eventBus,
 	}
 }
 
 type logService struct {
 	jobStore JobStore
-	client   *docker.Client
-}
-
-func (ls logService) GetLogs(job Job, output io.Writer) error {
-	for _, container := range job.Containers {
-		err := ls.client.Logs(docker.LogsOptions{
-			Container:    string(container),
-			OutputStream: output,
-			ErrorStream:  output,
-			Stdout:       true,
-			Stderr:       true,
-		})
+	logStore LogStore
+	client   Runtime
+	eventBus JobEventBus
+}
+
+func (ls logService) GetLogs(job Job, format LogFormat, output io.Writer) error {
+	for i, container := range job.Containers {
+		if err := ls.logs(job, container, i, format, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ls logService) GetCmdLogs(job Job, index int, format LogFormat, output io.Writer) error {
+	if index < 0 || index >= len(job.Cmds) {
+		return ErrCmdNotFound
+	}
+	if index >= len(job.Containers) {
+		return nil
+	}
+	return ls.logs(job, job.Containers[index], index, format, output)
+}
+
+func (ls logService) FollowLogs(ID JobID, format LogFormat, output io.Writer, closed <-chan bool) error {
+	// subscribe before looking at the job so no new containers are missed
+	_, events := ls.eventBus.Subscribe(ls.eventBus.Epoch(), ls.eventBus.LastSeq())
+	defer func() {
+		ls.eventBus.Unsubscribe(events)
+	}()
+
+	followed := make(map[Container]bool)
+	for {
+		job, err := ls.jobStore.Find(ID)
 		if err != nil {
-			log.Errorf("Error getting logs from container %s: %s", container, err)
 			return err
 		}
+		// containers are looked for by ID rather than by index,
+		// since retrying the job starts its list over
+		next := -1
+		for i, container := range job.Containers {
+			if !followed[container] {
+				next = i
+				break
+			}
+		}
+		if next >= 0 {
+			followed[job.Containers[next]] = true
+			if err := ls.followContainer(ID, job.Containers[next], next, format, output, closed); err != nil {
+				return err
+			}
+			continue
+		}
+		if jobDone(job) {
+			return nil
+		}
+
+		// wait for the job to change
+		select {
+		case _, ok := <-events:
+			if !ok {
+				// fell behind, but we only need to know something changed
+				_, events = ls.eventBus.Subscribe(ls.eventBus.Epoch(), ls.eventBus.LastSeq())
+			}
+		case <-closed:
+			return nil
+		}
+	}
+}
+
+// followContainer streams the logs of the container until it exits. Containers
+// are recorded before they're started, so it waits for that to happen first.
+func (ls logService) followContainer(ID JobID, container Container, cmdIndex int, format LogFormat, output io.Writer, closed <-chan bool) error {
+	for {
+		c, err := ls.client.InspectContainer(string(container))
+		if err != nil {
+			if _, ok := err.(*docker.NoSuchContainer); ok {
+				// removed, so there's nothing to follow
+				_, err := ls.storedLogs(ID, container, format, output)
+				return err
+			}
+			return err
+		}
+		if c.State.Running || !c.State.StartedAt.IsZero() {
+			break
+		}
+		job, err := ls.jobStore.Find(ID)
+		if err != nil {
+			return err
+		}
+		if jobDone(job) {
+			// the job ended before the container got to start
+			return nil
+		}
+		select {
+		case <-time.After(containerStartPollInterval):
+		case <-closed:
+			return nil
+		}
+	}
+
+	return ls.containerLogs(container, cmdIndex, format, output, true)
+}
+
+// logs writes the logs of the container which ran the command at
+// cmdIndex, preferring the archived ones over asking Docker
+func (ls logService) logs(job Job, container Container, cmdIndex int, format LogFormat, output io.Writer) error {
+	stored, err := ls.storedLogs(job.ID, container, format, output)
+	if stored {
+		return err
+	}
+	if containsContainer(job.RemovedContainers, container) {
+		// the logs went with the container
+		return nil
+	}
+	return ls.containerLogs(container, cmdIndex, format, output, false)
+}
+
+// storedLogs writes the archived logs of the container,
+// returning false if there are none
+func (ls logService) storedLogs(ID JobID, container Container, format LogFormat, output io.Writer) (bool, error) {
+	if ls.logStore == nil {
+		return false, nil
+	}
+	r, err := ls.logStore.Open(ID, container)
+	if err == ErrLogsNotFound {
+		return false, nil
+	}
+	if err != nil {
+		log.Errorf("Error opening stored logs of container %s: %s", container, err)
+		return true, err
+	}
+	defer r.Close()
+
+	emit := logLineEmitter(format, output)
+	decoder := json.NewDecoder(r)
+	for {
+		line := LogLine{}
+		err := decoder.Decode(&line)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a line may be half written if the container is still running
+			return true, nil
+		}
+		if err != nil {
+			log.Errorf("Error reading stored logs of container %s: %s", container, err)
+			return true, err
+		}
+		if err := emit(line); err != nil {
+			return true, err
+		}
+	}
+}
+
+// containerLogs writes the logs of the container which ran the
+// command at cmdIndex, following them until it exits if follow is set
+func (ls logService) containerLogs(container Container, cmdIndex int, format LogFormat, output io.Writer, follow bool) error {
+	opts := docker.LogsOptions{
+		Container:    string(container),
+		OutputStream: output,
+		ErrorStream:  output,
+		Follow:       follow,
+		Stdout:       true,
+		Stderr:       true,
+	}
+	var stdout, stderr *logLineWriter
+	if format == LogFormatNDJSON {
+		emit := logLineEmitter(format, output)
+		stdout = &logLineWriter{cmd: cmdIndex, stream: LogStreamStdout, emit: emit}
+		stderr = &logLineWriter{cmd: cmdIndex, stream: LogStreamStderr, emit: emit}
+		opts.OutputStream = stdout
+		opts.ErrorStream = stderr
+		opts.Timestamps = true
+	}
+
+	err := ls.client.Logs(opts)
+	if err == nil && stdout != nil {
+		err = stdout.Flush()
+		if err == nil {
+			err = stderr.Flush()
+		}
+	}
+	if err != nil {
+		log.Errorf("Error getting logs from container %s: %s", container, err)
+		return err
 	}
 	return nil
 }
+
+// jobDone returns whether the job has reached its final status
+// and won't be retried, so no more containers will be created
+func jobDone(job Job) bool {
+	if job.Status == JobStatusQueued || job.Status == JobStatusRunning {
+		return false
+	}
+	if job.Retry != nil {
+		if _, retry := job.Retry.nextRetry(job); retry {
+			return false
+		}
+	}
+	return true
+}
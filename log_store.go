@@ -0,0 +1,89 @@
+package dockworker
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	logFileSuffix = ".ndjson"
+)
+
+// LogStore keeps the logs of job containers after the containers
+// are gone. The logs of a container are stored as a LogLine
+// encoded as JSON on every line.
+type LogStore interface {
+	// Create starts the logs of the container over,
+	// returning a writer to store them with
+	Create(ID JobID, container Container) (io.WriteCloser, error)
+	// Open returns a reader of the stored logs of the container,
+	// or ErrLogsNotFound if there are none
+	Open(ID JobID, container Container) (io.ReadCloser, error)
+	// Size returns the number of bytes stored for the job
+	Size(ID JobID) (int64, error)
+	// Delete removes all the logs of the job
+	Delete(ID JobID) error
+}
+
+// NewFileLogStore creates a LogStore which keeps the logs
+// of each job in its own directory under the given one
+func NewFileLogStore(dir string) (LogStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create log directory %s: %s", dir, err)
+	}
+	return fileLogStore{
+		dir: dir,
+	}, nil
+}
+
+type fileLogStore struct {
+	dir string
+}
+
+func (store fileLogStore) Create(ID JobID, container Container) (io.WriteCloser, error) {
+	if err := os.MkdirAll(store.jobDir(ID), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(store.path(ID, container))
+}
+
+func (store fileLogStore) Open(ID JobID, container Container) (io.ReadCloser, error) {
+	f, err := os.Open(store.path(ID, container))
+	if os.IsNotExist(err) {
+		return nil, ErrLogsNotFound
+	}
+	return f, err
+}
+
+func (store fileLogStore) Size(ID JobID) (int64, error) {
+	files, err := ioutil.ReadDir(store.jobDir(ID))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	return size, nil
+}
+
+func (store fileLogStore) Delete(ID JobID) error {
+	return os.RemoveAll(store.jobDir(ID))
+}
+
+func (store fileLogStore) jobDir(ID JobID) string {
+	return filepath.Join(store.dir, strconv.Itoa(int(ID)))
+}
+
+func (store fileLogStore) path(ID JobID, container Container) string {
+	// container IDs come from Docker, but make sure
+	// they can't point outside of the directory
+	return filepath.Join(store.jobDir(ID), filepath.Base(string(container))+logFileSuffix)
+}
@@ -0,0 +1,65 @@
+package dockworker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLogStore(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+	store, err := NewFileLogStore(dir)
+	assert.NoError(t, err)
+
+	_, err = store.Open(1, "abc")
+	assert.Equal(t, ErrLogsNotFound, err, "Logs which were never stored shouldn't be found")
+
+	w, err := store.Create(1, "abc")
+	assert.NoError(t, err)
+	w.Write([]byte("first\n"))
+	w.Close()
+	w, _ = store.Create(1, "def")
+	w.Write([]byte("second\n"))
+	w.Close()
+
+	r, err := store.Open(1, "abc")
+	assert.NoError(t, err)
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "first\n", string(data))
+	size, err := store.Size(1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(13), size, "Size should add up every container of the job")
+
+	w, _ = store.Create(1, "abc")
+	w.Close()
+	size, _ = store.Size(1)
+	assert.Equal(t, int64(7), size, "Creating the logs again should start them over")
+
+	assert.NoError(t, store.Delete(1))
+	_, err = store.Open(1, "def")
+	assert.Equal(t, ErrLogsNotFound, err, "Logs should be gone once deleted")
+	size, _ = store.Size(1)
+	assert.Equal(t, int64(0), size)
+}
+
+func TestCappedLogSink(t *testing.T) {
+	output := &bytes.Buffer{}
+	sink := &cappedLogSink{writer: output, maxBytes: 150, remaining: 150}
+	now := time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, sink.write(LogLine{Cmd: 1, Stream: LogStreamStdout, Time: now, Line: "a line of output"}))
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Equal(t, 2, len(lines), "Only the lines which fit should be stored, then the marker")
+	assert.Contains(t, lines[0], "a line of output")
+	assert.Contains(t, lines[1], `"stream":"dockworker"`, "Last line should be the truncation marker")
+	assert.Contains(t, lines[1], "Logs truncated")
+}
@@ -0,0 +1,272 @@
+package dockworker
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// EnvAllowedVolumes is the environment variable which sets the
+	// volume names and host paths jobs may mount, as comma separated
+	// patterns such as build-* or /srv/shared/*
+	EnvAllowedVolumes = "DOCKWORKER_ALLOWED_VOLUMES"
+	// EnvCaches is the environment variable which sets the caches jobs
+	// may use, as comma separated name=path pairs such as gomod=/go/pkg/mod
+	EnvCaches = "DOCKWORKER_CACHES"
+
+	// the prefix of the names of cache volumes, which are
+	// followed by the name of the cache and the key
+	cacheVolumePrefix = "dockworker-cache."
+)
+
+// namePattern is what names which end up in volume or file names,
+// such as cache keys, may look like
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// VolumeMount mounts a Docker volume or a host path into the containers of a job
+type VolumeMount struct {
+	// Source is the name of the volume or an absolute host path
+	Source   string `json:"source"`
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+// TmpfsMount mounts an empty tmpfs into each container of a job
+type TmpfsMount struct {
+	Path string `json:"path"`
+	// Size is in bytes, zero for Docker's default
+	Size int64 `json:"size,omitempty"`
+}
+
+// CacheMount mounts the volume of a cache the operator
+// set up, shared by every job using the same key
+type CacheMount struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	// Path defaults to the path of the cache
+	Path string `json:"path,omitempty"`
+}
+
+// CacheVolume is the volume of a cache for one key
+type CacheVolume struct {
+	Name   string `json:"name"`
+	Key    string `json:"key"`
+	Volume string `json:"volume"`
+}
+
+// MountPolicy is what jobs are allowed to mount
+type MountPolicy struct {
+	// AllowedVolumes are path.Match patterns of the
+	// volume names and host paths jobs may mount
+	AllowedVolumes []string
+	// Caches are the paths of the caches jobs may use, by name
+	Caches map[string]string
+}
+
+// mountPolicyFromEnv reads the mount policy from the environment, exiting if it's invalid
+func mountPolicyFromEnv() MountPolicy {
+	policy := MountPolicy{
+		AllowedVolumes: []string{},
+		Caches:         make(map[string]string),
+	}
+	for _, pattern := range splitList(os.Getenv(EnvAllowedVolumes)) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Fatalf("Invalid pattern in %s: %s", EnvAllowedVolumes, pattern)
+		}
+		policy.AllowedVolumes = append(policy.AllowedVolumes, pattern)
+	}
+	for _, cache := range splitList(os.Getenv(EnvCaches)) {
+		parts := strings.SplitN(cache, "=", 2)
+		if len(parts) != 2 || !namePattern.MatchString(parts[0]) || !path.IsAbs(parts[1]) {
+			log.Fatalf("Invalid value for %s, expected name=/path: %s", EnvCaches, cache)
+		}
+		policy.Caches[parts[0]] = parts[1]
+	}
+	return policy
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(v string) []string {
+	items := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// allowsVolume returns whether the volume name or host path may be mounted
+func (policy MountPolicy) allowsVolume(source string) bool {
+	if strings.HasPrefix(source, cacheVolumePrefix) {
+		// caches are only mounted as caches
+		return false
+	}
+	if path.IsAbs(source) {
+		source = path.Clean(source)
+	}
+	for _, pattern := range policy.AllowedVolumes {
+		if ok, _ := path.Match(pattern, source); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns a ValidationError if the job mounts anything the
+// policy doesn't allow, and fills in the paths of its caches
+func (policy MountPolicy) apply(job *Job) error {
+	paths := make(map[string]bool)
+	checkPath := func(kind string, i int, p string) error {
+		if !path.IsAbs(p) || path.Clean(p) == "/" {
+			return validationErrorf("Path of %s %d must be an absolute path below /", kind, i)
+		}
+		if paths[path.Clean(p)] {
+			return validationErrorf("Path %s is mounted more than once", p)
+		}
+		paths[path.Clean(p)] = true
+		return nil
+	}
+
+	for i, volume := range job.Volumes {
+		if err := checkPath("volume", i, volume.Path); err != nil {
+			return err
+		}
+		if !policy.allowsVolume(volume.Source) {
+			return validationErrorf("Volume %q isn't allowed to be mounted", volume.Source)
+		}
+	}
+	for i, tmpfs := range job.Tmpfs {
+		if err := checkPath("tmpfs", i, tmpfs.Path); err != nil {
+			return err
+		}
+		if tmpfs.Size < 0 {
+			return validationErrorf("Size of tmpfs %d must not be negative", i)
+		}
+	}
+	for i := range job.Caches {
+		cache := &job.Caches[i]
+		cachePath, ok := policy.Caches[cache.Name]
+		if !ok {
+			return validationErrorf("There is no cache named %q", cache.Name)
+		}
+		if !namePattern.MatchString(cache.Key) {
+			return validationErrorf("Key of cache %d must only have letters, digits, - and _", i)
+		}
+		if cache.Path == "" {
+			cache.Path = cachePath
+		}
+		if err := checkPath("cache", i, cache.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheVolumeName returns the name of the volume of the cache for the key
+func cacheVolumeName(name, key string) string {
+	return cacheVolumePrefix + name + "." + key
+}
+
+// parseCacheVolumeName returns the cache and key of a
+// cache volume, or false if it isn't one
+func parseCacheVolumeName(volume string) (CacheVolume, bool) {
+	if !strings.HasPrefix(volume, cacheVolumePrefix) {
+		return CacheVolume{}, false
+	}
+	parts := strings.Split(strings.TrimPrefix(volume, cacheVolumePrefix), ".")
+	if len(parts) != 2 {
+		return CacheVolume{}, false
+	}
+	return CacheVolume{Name: parts[0], Key: parts[1], Volume: volume}, true
+}
+
+// applyMounts sets the mounts of the job in the host config of a container
+func applyMounts(job Job, hostConfig *docker.HostConfig) {
+	for _, volume := range job.Volumes {
+		bind := volume.Source + ":" + volume.Path
+		if volume.ReadOnly {
+			bind += ":ro"
+		}
+		hostConfig.Binds = append(hostConfig.Binds, bind)
+	}
+	for _, cache := range job.Caches {
+		hostConfig.Binds = append(hostConfig.Binds, cacheVolumeName(cache.Name, cache.Key)+":"+cache.Path)
+	}
+	if len(job.Tmpfs) > 0 {
+		hostConfig.Tmpfs = make(map[string]string)
+		for _, tmpfs := range job.Tmpfs {
+			options := ""
+			if tmpfs.Size > 0 {
+				options = fmt.Sprintf("size=%d", tmpfs.Size)
+			}
+			hostConfig.Tmpfs[tmpfs.Path] = options
+		}
+	}
+}
+
+// CacheManager lists and evicts the volumes of caches
+type CacheManager interface {
+	List() ([]CacheVolume, error)
+	// Evict removes the volume of the cache for the key, or for
+	// every key if it's empty, returning the volumes removed
+	Evict(name, key string) ([]CacheVolume, error)
+}
+
+// NewCacheManager returns a new CacheManager
+func NewCacheManager(client Runtime) CacheManager {
+	return cacheManager{
+		client: client,
+	}
+}
+
+type cacheManager struct {
+	client Runtime
+}
+
+func (cm cacheManager) List() ([]CacheVolume, error) {
+	volumes, err := cm.client.ListVolumes(docker.ListVolumesOptions{})
+	if err != nil {
+		return nil, err
+	}
+	caches := []CacheVolume{}
+	for _, volume := range volumes {
+		if cache, ok := parseCacheVolumeName(volume.Name); ok {
+			caches = append(caches, cache)
+		}
+	}
+	return caches, nil
+}
+
+func (cm cacheManager) Evict(name, key string) ([]CacheVolume, error) {
+	caches, err := cm.List()
+	if err != nil {
+		return nil, err
+	}
+	evicted := []CacheVolume{}
+	for _, cache := range caches {
+		if cache.Name != name || (key != "" && cache.Key != key) {
+			continue
+		}
+		if err := cm.client.RemoveVolume(cache.Volume); err != nil {
+			if err == docker.ErrVolumeInUse {
+				return evicted, ErrCacheInUse
+			}
+			if err != docker.ErrNoSuchVolume {
+				return evicted, err
+			}
+		}
+		log.Infof("Evicted cache %s for key %s", cache.Name, cache.Key)
+		evicted = append(evicted, cache)
+	}
+	if len(evicted) == 0 {
+		return evicted, ErrCacheNotFound
+	}
+	return evicted, nil
+}
@@ -0,0 +1,63 @@
+package dockworker
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMountPolicy(t *testing.T) {
+	policy := MountPolicy{
+		AllowedVolumes: []string{"build-*", "/srv/shared/*"},
+		Caches:         map[string]string{"gomod": "/go/pkg/mod"},
+	}
+	assert.True(t, policy.allowsVolume("build-output"))
+	assert.True(t, policy.allowsVolume("/srv/shared/data"))
+	assert.False(t, policy.allowsVolume("/srv/shared/../../etc"), "Host paths should be cleaned before matching")
+	assert.False(t, policy.allowsVolume("/etc"))
+	assert.False(t, policy.allowsVolume(cacheVolumeName("gomod", "project")), "Caches should only be mounted as caches")
+
+	job := Job{
+		Volumes: []VolumeMount{{Source: "build-output", Path: "/out"}},
+		Tmpfs:   []TmpfsMount{{Path: "/tmp", Size: 64 << 20}},
+		Caches:  []CacheMount{{Name: "gomod", Key: "project"}},
+	}
+	assert.NoError(t, policy.apply(&job))
+	assert.Equal(t, "/go/pkg/mod", job.Caches[0].Path, "Caches should default to their path")
+
+	invalid := []Job{
+		{Volumes: []VolumeMount{{Source: "/var/run/docker.sock", Path: "/var/run/docker.sock"}}},
+		{Volumes: []VolumeMount{{Source: "build-output", Path: "out"}}},
+		{Tmpfs: []TmpfsMount{{Path: "/"}}},
+		{Tmpfs: []TmpfsMount{{Path: "/tmp"}, {Path: "/tmp/"}}},
+		{Caches: []CacheMount{{Name: "npm", Key: "project"}}},
+		{Caches: []CacheMount{{Name: "gomod", Key: "../project"}}},
+	}
+	for i, job := range invalid {
+		err := policy.apply(&job)
+		_, ok := err.(ValidationError)
+		assert.True(t, ok, "Case %d: %+v should be rejected", i, job)
+	}
+}
+
+func TestApplyMounts(t *testing.T) {
+	job := Job{
+		Volumes: []VolumeMount{{Source: "build-output", Path: "/out", ReadOnly: true}},
+		Tmpfs:   []TmpfsMount{{Path: "/tmp", Size: 1024}, {Path: "/run"}},
+		Caches:  []CacheMount{{Name: "gomod", Key: "project", Path: "/go/pkg/mod"}},
+	}
+	hostConfig := &docker.HostConfig{}
+	applyMounts(job, hostConfig)
+	assert.Equal(t, []string{"build-output:/out:ro", "dockworker-cache.gomod.project:/go/pkg/mod"}, hostConfig.Binds)
+	assert.Equal(t, map[string]string{"/tmp": "size=1024", "/run": ""}, hostConfig.Tmpfs)
+}
+
+func TestCacheVolumeName(t *testing.T) {
+	cache, ok := parseCacheVolumeName(cacheVolumeName("gomod", "project"))
+	assert.True(t, ok)
+	assert.Equal(t, CacheVolume{Name: "gomod", Key: "project", Volume: "dockworker-cache.gomod.project"}, cache)
+
+	_, ok = parseCacheVolumeName("build-output")
+	assert.False(t, ok)
+}
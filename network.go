@@ -0,0 +1,121 @@
+package dockworker
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// EnvAllowedNetworks is the environment variable which sets the
+	// network modes and user-defined networks jobs may use, comma separated
+	EnvAllowedNetworks = "DOCKWORKER_ALLOWED_NETWORKS"
+	// EnvDefaultNetwork is the environment variable which sets the
+	// network mode of jobs which don't ask for one
+	EnvDefaultNetwork = "DOCKWORKER_DEFAULT_NETWORK"
+	// EnvAllowDNS is the environment variable which sets
+	// whether jobs may set their own DNS servers
+	EnvAllowDNS = "DOCKWORKER_ALLOW_DNS"
+	// EnvAllowExtraHosts is the environment variable which sets
+	// whether jobs may add entries to their /etc/hosts
+	EnvAllowExtraHosts = "DOCKWORKER_ALLOW_EXTRA_HOSTS"
+
+	// NetworkModeBridge is Docker's default bridge network
+	NetworkModeBridge = "bridge"
+	// NetworkModeNone gives containers no networking besides loopback
+	NetworkModeNone = "none"
+	// NetworkModeHost runs containers in the host's network namespace
+	NetworkModeHost = "host"
+)
+
+// DefaultAllowedNetworks are the network modes jobs may use if none are configured
+var DefaultAllowedNetworks = []string{NetworkModeBridge, NetworkModeNone}
+
+// NetworkPolicy is which networking options jobs may use
+type NetworkPolicy struct {
+	// AllowedNetworks are the network modes and names
+	// of user-defined networks jobs may use
+	AllowedNetworks []string
+	// DefaultNetwork is the network of jobs which
+	// don't ask for one, empty for Docker's default
+	DefaultNetwork  string
+	AllowDNS        bool
+	AllowExtraHosts bool
+}
+
+// networkPolicyFromEnv reads the network policy from the environment, exiting if it's invalid
+func networkPolicyFromEnv() NetworkPolicy {
+	policy := NetworkPolicy{
+		AllowedNetworks: splitList(os.Getenv(EnvAllowedNetworks)),
+		DefaultNetwork:  os.Getenv(EnvDefaultNetwork),
+		AllowDNS:        boolFromEnv(EnvAllowDNS, true),
+		AllowExtraHosts: boolFromEnv(EnvAllowExtraHosts, true),
+	}
+	if len(policy.AllowedNetworks) == 0 {
+		policy.AllowedNetworks = DefaultAllowedNetworks
+	}
+	if policy.DefaultNetwork != "" && !policy.allowsNetwork(policy.DefaultNetwork) {
+		log.Fatalf("The default network %s isn't one of the allowed networks %s",
+			policy.DefaultNetwork, strings.Join(policy.AllowedNetworks, ","))
+	}
+	return policy
+}
+
+func (policy NetworkPolicy) allowsNetwork(mode string) bool {
+	if strings.HasPrefix(mode, "container:") {
+		// joining another container's network
+		// would let a job see into it
+		return false
+	}
+	for _, allowed := range policy.AllowedNetworks {
+		if mode == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns a ValidationError if the job uses networking the
+// policy doesn't allow, and fills in the default network
+func (policy NetworkPolicy) apply(job *Job) error {
+	if job.NetworkMode == "" {
+		job.NetworkMode = policy.DefaultNetwork
+	}
+	if job.NetworkMode != "" && !policy.allowsNetwork(job.NetworkMode) {
+		return validationErrorf("Network %q isn't allowed, jobs may use %s",
+			job.NetworkMode, strings.Join(policy.AllowedNetworks, ", "))
+	}
+	if len(job.DNS) > 0 && !policy.AllowDNS {
+		return validationErrorf("Jobs aren't allowed to set DNS servers")
+	}
+	if len(job.ExtraHosts) > 0 && !policy.AllowExtraHosts {
+		return validationErrorf("Jobs aren't allowed to set extra hosts")
+	}
+	if (len(job.DNS) > 0 || len(job.ExtraHosts) > 0) &&
+		(job.NetworkMode == NetworkModeNone || job.NetworkMode == NetworkModeHost) {
+		return validationErrorf("DNS servers and extra hosts can't be set with the %s network", job.NetworkMode)
+	}
+	for _, dns := range job.DNS {
+		if net.ParseIP(dns) == nil {
+			return validationErrorf("DNS server %q must be an IP address", dns)
+		}
+	}
+	for _, host := range job.ExtraHosts {
+		// the address may be IPv6, so split at the first colon
+		parts := strings.SplitN(host, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || net.ParseIP(parts[1]) == nil {
+			return validationErrorf("Extra host %q must be written as name:ip", host)
+		}
+	}
+	return nil
+}
+
+// applyNetwork sets the networking of the job in the host config of a container
+func applyNetwork(job Job, hostConfig *docker.HostConfig) {
+	hostConfig.NetworkMode = job.NetworkMode
+	hostConfig.DNS = job.DNS
+	hostConfig.ExtraHosts = job.ExtraHosts
+}
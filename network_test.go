@@ -0,0 +1,56 @@
+package dockworker
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkPolicy(t *testing.T) {
+	policy := NetworkPolicy{
+		AllowedNetworks: []string{NetworkModeNone, "integration"},
+		DefaultNetwork:  NetworkModeNone,
+		AllowDNS:        true,
+		AllowExtraHosts: false,
+	}
+
+	job := Job{}
+	assert.NoError(t, policy.apply(&job))
+	assert.Equal(t, NetworkModeNone, job.NetworkMode, "Jobs should get the default network")
+
+	job = Job{NetworkMode: "integration", DNS: []string{"10.0.0.2", "2001:db8::1"}}
+	assert.NoError(t, policy.apply(&job))
+
+	invalid := []Job{
+		{NetworkMode: NetworkModeBridge},
+		{NetworkMode: "container:abc"},
+		{NetworkMode: "integration", DNS: []string{"dns.example.com"}},
+		{NetworkMode: "integration", ExtraHosts: []string{"db:10.0.0.3"}},
+		{DNS: []string{"10.0.0.2"}},
+	}
+	for i, job := range invalid {
+		err := policy.apply(&job)
+		_, ok := err.(ValidationError)
+		assert.True(t, ok, "Case %d: %+v should be rejected", i, job)
+	}
+
+	policy.AllowExtraHosts = true
+	job = Job{NetworkMode: "integration", ExtraHosts: []string{"db:10.0.0.3", "v6:2001:db8::2"}}
+	assert.NoError(t, policy.apply(&job))
+	job = Job{NetworkMode: "integration", ExtraHosts: []string{"db"}}
+	assert.Error(t, policy.apply(&job))
+
+	policy = NetworkPolicy{AllowedNetworks: DefaultAllowedNetworks}
+	job = Job{}
+	assert.NoError(t, policy.apply(&job))
+	assert.Equal(t, "", job.NetworkMode, "Jobs should be left on Docker's default network without a default")
+}
+
+func TestApplyNetwork(t *testing.T) {
+	hostConfig := &docker.HostConfig{}
+	applyNetwork(Job{NetworkMode: "integration", DNS: []string{"10.0.0.2"}, ExtraHosts: []string{"db:10.0.0.3"}}, hostConfig)
+	assert.Equal(t, "integration", hostConfig.NetworkMode)
+	assert.Equal(t, []string{"10.0.0.2"}, hostConfig.DNS)
+	assert.Equal(t, []string{"db:10.0.0.3"}, hostConfig.ExtraHosts)
+}
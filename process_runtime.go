@@ -0,0 +1,961 @@
+package dockworker
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// EnvProcessDir is the environment variable which sets the directory
+	// the process runtime keeps the files and logs of commands in
+	EnvProcessDir = "DOCKWORKER_PROCESS_DIR"
+	// EnvProcessRootfsDir is the environment variable which sets the
+	// directory of prepared root filesystems the process runtime maps
+	// images to, each a directory named after the image
+	EnvProcessRootfsDir = "DOCKWORKER_PROCESS_ROOTFS_DIR"
+	// EnvProcessSandbox is the environment variable which sets whether
+	// the process runtime runs commands in Linux namespaces
+	EnvProcessSandbox = "DOCKWORKER_PROCESS_SANDBOX"
+
+	// the PATH of commands which don't set one, the same Docker uses
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	// the version the process runtime reports instead of Docker's
+	processRuntimeVersion = "process"
+)
+
+// ProcessRuntimeConfig is how the process runtime runs commands
+type ProcessRuntimeConfig struct {
+	// Dir is where the files and logs of commands are kept.
+	// It's emptied when the runtime starts.
+	Dir string
+	// RootfsDir is the directory of prepared root filesystems
+	// images are mapped to. If it's empty images are ignored.
+	RootfsDir string
+	// Sandbox is whether commands run in their own user, mount, PID,
+	// UTS and IPC namespaces, chrooted into the root filesystem of
+	// their image if there is one. Only supported on Linux.
+	Sandbox bool
+}
+
+// processRuntimeConfigFromEnv reads the process runtime config from the environment, exiting if it's invalid
+func processRuntimeConfigFromEnv(dataDir string) ProcessRuntimeConfig {
+	config := ProcessRuntimeConfig{
+		Dir:       os.Getenv(EnvProcessDir),
+		RootfsDir: os.Getenv(EnvProcessRootfsDir),
+		Sandbox:   boolFromEnv(EnvProcessSandbox, false),
+	}
+	if config.Dir == "" && dataDir != "" {
+		config.Dir = filepath.Join(dataDir, "processes")
+	}
+	if config.RootfsDir != "" && !config.Sandbox {
+		log.Fatalf("%s can only be used with %s, commands are chrooted into the root filesystems", EnvProcessRootfsDir, EnvProcessSandbox)
+	}
+	return config
+}
+
+// NewProcessRuntime returns a Runtime which runs each command as a local
+// process instead of in a container. The commands of a job attempt share a
+// root directory, which committing a container passes on to the next one.
+func NewProcessRuntime(config ProcessRuntimeConfig) (Runtime, error) {
+	if config.Sandbox && !sandboxSupported {
+		return nil, fmt.Errorf("Sandboxing processes is only supported on Linux")
+	}
+	if !config.Sandbox {
+		log.Warn("The process runtime isn't sandboxed, commands run as the server's user with full access to the host")
+	}
+	for _, dir := range []string{"roots", "containers"} {
+		// nothing survives a restart, so clear out what's left
+		if err := os.RemoveAll(filepath.Join(config.Dir, dir)); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Join(config.Dir, dir), 0700); err != nil {
+			return nil, err
+		}
+	}
+	pr := &processRuntime{
+		config:     config,
+		lock:       &sync.Mutex{},
+		images:     make(map[string]*processImage),
+		containers: make(map[string]*processContainer),
+		roots:      make(map[string]int),
+		eventsLock: &sync.Mutex{},
+		wake:       make(chan bool, 1),
+	}
+	go pr.sendEvents()
+	return pr, nil
+}
+
+type processImage struct {
+	image *docker.Image
+	// rootfs is the root filesystem containers created from
+	// a base image start with, if it was mapped to one
+	rootfs string
+	// root is the root directory of the container a
+	// committed image came from, which it passes on
+	root string
+}
+
+type processContainer struct {
+	container *docker.Container
+	state     docker.State
+	root      string
+	logFile   string
+	cmd       *exec.Cmd
+	// changed is closed and replaced whenever logs are
+	// written or the state changes, for followers to wait on
+	changed chan bool
+	done    chan bool
+}
+
+type processListener struct {
+	events  chan<- *docker.APIEvents
+	removed chan bool
+}
+
+type processRuntime struct {
+	config     ProcessRuntimeConfig
+	lock       *sync.Mutex
+	images     map[string]*processImage
+	containers map[string]*processContainer
+	// roots counts the containers and images using each root directory,
+	// which is removed once nothing does
+	roots  map[string]int
+	nextID int
+	// events are queued up for the listeners and sent in order,
+	// apart from the locks so nothing waits on slow listeners
+	eventsLock *sync.Mutex
+	events     []*docker.APIEvents
+	listeners  []*processListener
+	wake       chan bool
+}
+
+func (pr *processRuntime) newID(kind string) string {
+	pr.nextID++
+	return fmt.Sprintf("%s-%d-%d", kind, time.Now().UnixNano(), pr.nextID)
+}
+
+// emit queues an event about the container, it never blocks
+func (pr *processRuntime) emit(status, ID string) {
+	now := time.Now()
+	pr.eventsLock.Lock()
+	pr.events = append(pr.events, &docker.APIEvents{Status: status, ID: ID, Time: now.Unix(), TimeNano: now.UnixNano()})
+	pr.eventsLock.Unlock()
+	select {
+	case pr.wake <- true:
+	default:
+	}
+}
+
+func (pr *processRuntime) sendEvents() {
+	for range pr.wake {
+		for {
+			pr.eventsLock.Lock()
+			events := pr.events
+			pr.events = nil
+			listeners := pr.listeners
+			pr.eventsLock.Unlock()
+			if len(events) == 0 {
+				break
+			}
+			for _, event := range events {
+				for _, listener := range listeners {
+					select {
+					case listener.events <- event:
+					case <-listener.removed:
+					}
+				}
+			}
+		}
+	}
+}
+
+// changed wakes up everything waiting on the container, it must be called with the lock
+func (pr *processRuntime) changed(c *processContainer) {
+	close(c.changed)
+	c.changed = make(chan bool)
+}
+
+// release stops a container or image using the root
+// directory, it must be called with the lock
+func (pr *processRuntime) release(root string) {
+	pr.roots[root]--
+	if pr.roots[root] > 0 {
+		return
+	}
+	delete(pr.roots, root)
+	if err := os.RemoveAll(root); err != nil {
+		log.Warnf("Failed to remove process root %s: %s", root, err)
+	}
+}
+
+func (pr *processRuntime) Ping() error {
+	return nil
+}
+
+func (pr *processRuntime) Info() (*docker.DockerInfo, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return &docker.DockerInfo{Name: hostname, ServerVersion: processRuntimeVersion}, nil
+}
+
+// PullImage only checks the image is there, since there's nowhere to pull from
+func (pr *processRuntime) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	name := opts.Repository
+	if opts.Tag != "" {
+		name += ":" + opts.Tag
+	}
+	if strings.HasPrefix(opts.Tag, "sha256:") {
+		name = opts.Repository + "@" + opts.Tag
+	}
+	if _, err := pr.InspectImage(name); err != nil {
+		return fmt.Errorf("Image %s has no root filesystem in %s, images can't be pulled", name, pr.config.RootfsDir)
+	}
+	return nil
+}
+
+// findImage returns the image with the ID, or the base image
+// with the name, it must be called with the lock
+func (pr *processRuntime) findImage(name string) (*processImage, bool) {
+	if image, ok := pr.images[name]; ok {
+		return image, true
+	}
+	ID := "process:" + name
+	if image, ok := pr.images[ID]; ok {
+		return image, true
+	}
+	image := &processImage{image: &docker.Image{ID: ID, Config: &docker.Config{}}}
+	if pr.config.RootfsDir != "" {
+		rootfs := filepath.Join(pr.config.RootfsDir, filepath.FromSlash(path.Clean("/"+name)))
+		if info, err := os.Stat(rootfs); err != nil || !info.IsDir() {
+			return nil, false
+		}
+		image.rootfs = rootfs
+	}
+	pr.images[ID] = image
+	return image, true
+}
+
+func (pr *processRuntime) InspectImage(name string) (*docker.Image, error) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	image, ok := pr.findImage(name)
+	if !ok {
+		return nil, docker.ErrNoSuchImage
+	}
+	copied := *image.image
+	return &copied, nil
+}
+
+func (pr *processRuntime) RemoveImage(name string) error {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	image, ok := pr.images[name]
+	if !ok {
+		return docker.ErrNoSuchImage
+	}
+	delete(pr.images, name)
+	if image.root != "" {
+		pr.release(image.root)
+	}
+	return nil
+}
+
+func (pr *processRuntime) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	if hc := opts.HostConfig; hc != nil {
+		if len(hc.Binds) > 0 || len(hc.Tmpfs) > 0 {
+			return nil, fmt.Errorf("The process runtime can't mount volumes")
+		}
+		switch hc.NetworkMode {
+		case "", NetworkModeBridge, NetworkModeHost:
+		case NetworkModeNone:
+			// only a network namespace takes the network away
+			if !pr.config.Sandbox {
+				return nil, fmt.Errorf("The process runtime can only run without a network when sandboxed")
+			}
+		default:
+			return nil, fmt.Errorf("The process runtime can't use network %s", hc.NetworkMode)
+		}
+		if hc.Memory != 0 || hc.MemorySwap != 0 || hc.CPUShares != 0 || hc.CPUQuota != 0 ||
+			hc.PidsLimit != 0 || len(hc.Ulimits) > 0 {
+			return nil, fmt.Errorf("The process runtime can't enforce resource limits")
+		}
+	}
+	if opts.Config.User != "" {
+		return nil, fmt.Errorf("The process runtime can't run commands as another user")
+	}
+
+	pr.lock.Lock()
+	image, ok := pr.findImage(opts.Config.Image)
+	ID := pr.newID("process")
+	pr.lock.Unlock()
+	if !ok {
+		return nil, docker.ErrNoSuchImage
+	}
+
+	root := image.root
+	if root == "" {
+		// a base image, so the job starts over in a new root
+		root = filepath.Join(pr.config.Dir, "roots", ID)
+		if err := os.Mkdir(root, 0700); err != nil {
+			return nil, err
+		}
+		if image.rootfs != "" {
+			if err := copyTree(image.rootfs, root); err != nil {
+				os.RemoveAll(root)
+				return nil, fmt.Errorf("Failed to copy root filesystem %s: %s", image.rootfs, err)
+			}
+		}
+	}
+
+	config := *opts.Config
+	c := &processContainer{
+		container: &docker.Container{
+			ID:         ID,
+			Created:    time.Now(),
+			Config:     &config,
+			Image:      image.image.ID,
+			HostConfig: opts.HostConfig,
+		},
+		root:    root,
+		logFile: filepath.Join(pr.config.Dir, "containers", ID+".log"),
+		changed: make(chan bool),
+		done:    make(chan bool),
+	}
+	if err := ioutil.WriteFile(c.logFile, nil, 0600); err != nil {
+		return nil, err
+	}
+
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	pr.roots[root]++
+	pr.containers[ID] = c
+	pr.emit("create", ID)
+	return pr.inspect(c), nil
+}
+
+func (pr *processRuntime) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	c, ok := pr.containers[id]
+	if !ok {
+		return &docker.NoSuchContainer{ID: id}
+	}
+	if c.state.Running || !c.state.StartedAt.IsZero() {
+		return &docker.ContainerAlreadyRunning{ID: id}
+	}
+
+	cmd, err := pr.command(c)
+	if err != nil {
+		return &docker.Error{Status: 500, Message: err.Error()}
+	}
+	stdout := &processLogWriter{runtime: pr, container: c, stream: LogStreamStdout}
+	stderr := &processLogWriter{runtime: pr, container: c, stream: LogStreamStderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Start(); err != nil {
+		return &docker.Error{Status: 500, Message: err.Error()}
+	}
+	c.cmd = cmd
+	c.state.Running = true
+	c.state.Pid = cmd.Process.Pid
+	c.state.StartedAt = time.Now()
+	pr.changed(c)
+	pr.emit("start", id)
+
+	go func() {
+		exitCode := exitCode(cmd.Wait())
+		pr.lock.Lock()
+		defer pr.lock.Unlock()
+		stdout.flush()
+		stderr.flush()
+		c.state.Running = false
+		c.state.Pid = 0
+		c.state.ExitCode = exitCode
+		c.state.FinishedAt = time.Now()
+		pr.changed(c)
+		close(c.done)
+		pr.emit("die", id)
+	}()
+	return nil
+}
+
+// command returns the command to run the container with
+func (pr *processRuntime) command(c *processContainer) (*exec.Cmd, error) {
+	config := c.container.Config
+	args := config.Cmd
+	if len(config.Entrypoint) > 0 && config.Entrypoint[0] != "" {
+		args = append(append([]string{}, config.Entrypoint...), args...)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("No command to run")
+	}
+
+	env := config.Env
+	envPath := defaultPath
+	for _, v := range env {
+		if strings.HasPrefix(v, "PATH=") {
+			envPath = strings.TrimPrefix(v, "PATH=")
+		}
+	}
+	if envPath == defaultPath {
+		env = append(append([]string{}, env...), "PATH="+defaultPath)
+	}
+
+	// commands see the root directory as / when they're
+	// chrooted into it, and run from it when they aren't
+	chroot := pr.config.Sandbox && pr.config.RootfsDir != ""
+	workdir := path.Clean("/" + config.WorkingDir)
+	if err := os.MkdirAll(filepath.Join(c.root, filepath.FromSlash(workdir)), 0755); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(c.root, filepath.FromSlash(workdir))
+	searchRoot := "/"
+	if chroot {
+		dir = workdir
+		searchRoot = c.root
+	}
+	file, err := lookPath(searchRoot, args[0], envPath)
+	if err != nil {
+		return nil, err
+	}
+
+	networkMode := ""
+	if c.container.HostConfig != nil {
+		networkMode = c.container.HostConfig.NetworkMode
+	}
+	attr, err := processAttr(pr.config.Sandbox, chroot, c.root, networkMode)
+	if err != nil {
+		return nil, err
+	}
+	return &exec.Cmd{
+		Path:        file,
+		Args:        args,
+		Env:         env,
+		Dir:         dir,
+		SysProcAttr: attr,
+	}, nil
+}
+
+// lookPath finds the executable file in the PATH, as seen from the
+// root directory, returning its path relative to the root
+func lookPath(root, file, envPath string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	for _, dir := range filepath.SplitList(envPath) {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := path.Join(dir, file)
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(candidate)))
+		if err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("exec: %q: executable file not found in $PATH", file)
+}
+
+// exitCode returns the exit code of a process the same way a
+// shell does, with 128 plus the signal if it was killed by one
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+	log.Warnf("Error waiting for process: %s", err)
+	return -1
+}
+
+func (pr *processRuntime) StopContainer(id string, timeout uint) error {
+	pr.lock.Lock()
+	c, ok := pr.containers[id]
+	if !ok {
+		pr.lock.Unlock()
+		return &docker.NoSuchContainer{ID: id}
+	}
+	if !c.state.Running {
+		pr.lock.Unlock()
+		return &docker.ContainerNotRunning{ID: id}
+	}
+	process := c.cmd.Process
+	pr.lock.Unlock()
+
+	if err := signalProcess(process, syscall.SIGTERM); err != nil {
+		log.Warnf("Failed to terminate process %d of %s: %s", process.Pid, id, err)
+	}
+	select {
+	case <-c.done:
+	case <-time.After(time.Duration(timeout) * time.Second):
+		if err := signalProcess(process, syscall.SIGKILL); err != nil {
+			log.Warnf("Failed to kill process %d of %s: %s", process.Pid, id, err)
+		}
+		<-c.done
+	}
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	pr.emit("stop", id)
+	return nil
+}
+
+func (pr *processRuntime) WaitContainer(id string) (int, error) {
+	pr.lock.Lock()
+	c, ok := pr.containers[id]
+	pr.lock.Unlock()
+	if !ok {
+		return 0, &docker.NoSuchContainer{ID: id}
+	}
+	<-c.done
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	return c.state.ExitCode, nil
+}
+
+// inspect returns a copy of the container, it must be called with the lock
+func (pr *processRuntime) inspect(c *processContainer) *docker.Container {
+	container := *c.container
+	container.State = c.state
+	return &container
+}
+
+func (pr *processRuntime) InspectContainer(id string) (*docker.Container, error) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	c, ok := pr.containers[id]
+	if !ok {
+		return nil, &docker.NoSuchContainer{ID: id}
+	}
+	return pr.inspect(c), nil
+}
+
+func (pr *processRuntime) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	containers := []docker.APIContainers{}
+	for _, c := range pr.containers {
+		if !opts.All && !c.state.Running {
+			continue
+		}
+		if !hasLabels(c.container.Config.Labels, opts.Filters["label"]) {
+			continue
+		}
+		containers = append(containers, docker.APIContainers{
+			ID:      c.container.ID,
+			Image:   c.container.Image,
+			Created: c.container.Created.Unix(),
+			Labels:  c.container.Config.Labels,
+		})
+	}
+	return containers, nil
+}
+
+// hasLabels returns whether the labels match every key or key=value filter
+func hasLabels(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, "=", 2)
+		value, ok := labels[parts[0]]
+		if !ok || (len(parts) == 2 && value != parts[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (pr *processRuntime) CommitContainer(opts docker.CommitContainerOptions) (*docker.Image, error) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	c, ok := pr.containers[opts.Container]
+	if !ok {
+		return nil, &docker.NoSuchContainer{ID: opts.Container}
+	}
+	config := *c.container.Config
+	image := &processImage{
+		image: &docker.Image{
+			ID:        pr.newID("image"),
+			Parent:    c.container.Image,
+			Container: c.container.ID,
+			Created:   time.Now(),
+			Config:    &config,
+		},
+		root: c.root,
+	}
+	pr.roots[c.root]++
+	pr.images[image.image.ID] = image
+	pr.emit("commit", c.container.ID)
+	copied := *image.image
+	return &copied, nil
+}
+
+func (pr *processRuntime) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	pr.lock.Lock()
+	c, ok := pr.containers[opts.ID]
+	if !ok {
+		pr.lock.Unlock()
+		return &docker.NoSuchContainer{ID: opts.ID}
+	}
+	if c.state.Running {
+		if !opts.Force {
+			pr.lock.Unlock()
+			return &docker.Error{Status: 409, Message: fmt.Sprintf("Container %s is running", opts.ID)}
+		}
+		process := c.cmd.Process
+		pr.lock.Unlock()
+		signalProcess(process, syscall.SIGKILL)
+		<-c.done
+		pr.lock.Lock()
+	}
+	defer pr.lock.Unlock()
+	if _, ok := pr.containers[opts.ID]; !ok {
+		// removed while it was being killed
+		return nil
+	}
+	delete(pr.containers, opts.ID)
+	os.Remove(c.logFile)
+	pr.release(c.root)
+	pr.emit("destroy", opts.ID)
+	return nil
+}
+
+// containerPath returns where the path in the container is in its root
+// directory, making sure no symlink along the way leads out of it
+func (pr *processRuntime) containerPath(id, name string) (string, error) {
+	pr.lock.Lock()
+	c, ok := pr.containers[id]
+	pr.lock.Unlock()
+	if !ok {
+		return "", &docker.NoSuchContainer{ID: id}
+	}
+	return securePath(c.root, name)
+}
+
+// securePath joins the name to the root, returning an error
+// if the existing part of the path resolves outside of the root
+func securePath(root, name string) (string, error) {
+	p := filepath.Join(root, filepath.FromSlash(path.Clean("/"+name)))
+	existing := p
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	if resolved != resolvedRoot && !strings.HasPrefix(resolved, resolvedRoot+string(filepath.Separator)) {
+		return "", fmt.Errorf("Path %s leads out of the container", name)
+	}
+	return p, nil
+}
+
+func (pr *processRuntime) UploadToContainer(id string, opts docker.UploadToContainerOptions) error {
+	tr := tar.NewReader(opts.InputStream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &docker.Error{Status: 400, Message: err.Error()}
+		}
+		target, err := pr.containerPath(id, path.Join(opts.Path, header.Name))
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode|0700)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(target, tr, mode)
+		case tar.TypeSymlink:
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = os.Symlink(header.Linkname, target)
+			}
+		default:
+			log.Debugf("Skipping %s in archive for %s, only files, directories and symlinks are extracted", header.Name, id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeFile(name string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (pr *processRuntime) DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error {
+	source, err := pr.containerPath(id, opts.Path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(source); os.IsNotExist(err) {
+		return &docker.Error{Status: 404, Message: fmt.Sprintf("Could not find the file %s in container %s", opts.Path, id)}
+	}
+
+	// like Docker, entries are named from the last element of the path
+	tw := tar.NewWriter(opts.OutputStream)
+	base := filepath.Dir(source)
+	err = filepath.Walk(source, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, name)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(name); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func (pr *processRuntime) Logs(opts docker.LogsOptions) error {
+	offset := int64(0)
+	// the streams partway through a line, which
+	// like Docker's only get one timestamp
+	continued := make(map[LogStream]bool)
+	for {
+		pr.lock.Lock()
+		c, ok := pr.containers[opts.Container]
+		if !ok {
+			pr.lock.Unlock()
+			return &docker.NoSuchContainer{ID: opts.Container}
+		}
+		data, err := readFrom(c.logFile, offset)
+		running := c.state.Running
+		changed := c.changed
+		pr.lock.Unlock()
+		if err != nil {
+			return err
+		}
+		offset += int64(len(data))
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for decoder.More() {
+			line := LogLine{}
+			if err := decoder.Decode(&line); err != nil {
+				return err
+			}
+			w := opts.OutputStream
+			if line.Stream == LogStreamStderr {
+				w = opts.ErrorStream
+			}
+			if (line.Stream == LogStreamStderr && !opts.Stderr) || (line.Stream == LogStreamStdout && !opts.Stdout) {
+				continue
+			}
+			text := line.Line
+			if !line.Partial {
+				text += "\n"
+			}
+			if opts.Timestamps && !continued[line.Stream] {
+				text = line.Time.Format(time.RFC3339Nano) + " " + text
+			}
+			continued[line.Stream] = line.Partial
+			if _, err := io.WriteString(w, text); err != nil {
+				return err
+			}
+		}
+		if !opts.Follow || !running {
+			return nil
+		}
+		<-changed
+	}
+}
+
+// readFrom reads the rest of the file from the offset
+func readFrom(name string, offset int64) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, 0); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(f)
+}
+
+// ListVolumes returns no volumes, the process runtime has none
+func (pr *processRuntime) ListVolumes(opts docker.ListVolumesOptions) ([]docker.Volume, error) {
+	return []docker.Volume{}, nil
+}
+
+func (pr *processRuntime) RemoveVolume(name string) error {
+	return docker.ErrNoSuchVolume
+}
+
+func (pr *processRuntime) AddEventListener(listener chan<- *docker.APIEvents) error {
+	pr.eventsLock.Lock()
+	defer pr.eventsLock.Unlock()
+	pr.listeners = append(pr.listeners, &processListener{events: listener, removed: make(chan bool)})
+	return nil
+}
+
+func (pr *processRuntime) RemoveEventListener(listener chan *docker.APIEvents) error {
+	pr.eventsLock.Lock()
+	defer pr.eventsLock.Unlock()
+	listeners := []*processListener{}
+	for _, l := range pr.listeners {
+		if l.events == (chan<- *docker.APIEvents)(listener) {
+			close(l.removed)
+			continue
+		}
+		listeners = append(listeners, l)
+	}
+	pr.listeners = listeners
+	return nil
+}
+
+// processLogWriter stores what a process writes to
+// the log file of its container, line by line
+type processLogWriter struct {
+	runtime   *processRuntime
+	container *processContainer
+	stream    LogStream
+	partial   []byte
+}
+
+func (w *processLogWriter) Write(p []byte) (int, error) {
+	w.runtime.lock.Lock()
+	defer w.runtime.lock.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		n, complete, ok := nextLogLine(w.partial)
+		if !ok {
+			return len(p), nil
+		}
+		if err := w.add(w.partial[:n], !complete); err != nil {
+			return 0, err
+		}
+		if complete {
+			n++
+		}
+		w.partial = w.partial[n:]
+	}
+}
+
+// flush stores an unfinished last line, it must be called with the lock
+func (w *processLogWriter) flush() {
+	if len(w.partial) == 0 {
+		return
+	}
+	if err := w.add(w.partial, true); err != nil {
+		log.Errorf("Error writing logs of %s: %s", w.container.container.ID, err)
+	}
+	w.partial = nil
+}
+
+func (w *processLogWriter) add(line []byte, partial bool) error {
+	data, err := json.Marshal(LogLine{Stream: w.stream, Time: time.Now(), Line: string(line), Partial: partial})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.container.logFile, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	w.runtime.changed(w.container)
+	return err
+}
+
+// copyTree copies the files, directories and symlinks in src into dst
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return writeFile(target, f, info.Mode().Perm())
+		}
+		// devices and the like can't be copied without privileges
+		return nil
+	})
+}
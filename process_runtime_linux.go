@@ -0,0 +1,40 @@
+//go:build linux
+// +build linux
+
+package dockworker
+
+import (
+	"os"
+	"syscall"
+)
+
+// sandboxSupported is whether commands can be sandboxed on this platform
+const sandboxSupported = true
+
+// processAttr returns the attributes of the process of a command, running
+// it in its own namespaces if it's sandboxed, as root in a user namespace
+// mapped to the user running the server
+func processAttr(sandbox, chroot bool, root, networkMode string) (*syscall.SysProcAttr, error) {
+	// a process group, so stopping the command stops what it started
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if !sandbox {
+		return attr, nil
+	}
+	attr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+		syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC
+	if networkMode == NetworkModeNone {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+	if chroot {
+		attr.Chroot = root
+	}
+	return attr, nil
+}
+
+// signalProcess sends the signal to the process group of the command
+func signalProcess(p *os.Process, sig syscall.Signal) error {
+	return syscall.Kill(-p.Pid, sig)
+}
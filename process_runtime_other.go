@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package dockworker
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// sandboxSupported is whether commands can be sandboxed on this platform
+const sandboxSupported = false
+
+// processAttr returns the attributes of the process of a command
+func processAttr(sandbox, chroot bool, root, networkMode string) (*syscall.SysProcAttr, error) {
+	if sandbox {
+		return nil, fmt.Errorf("Sandboxing processes is only supported on Linux")
+	}
+	return nil, nil
+}
+
+// signalProcess kills the process of the command, signals other
+// than killing it aren't supported on every platform
+func signalProcess(p *os.Process, sig syscall.Signal) error {
+	return p.Kill()
+}
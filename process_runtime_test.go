@@ -0,0 +1,201 @@
+//go:build linux
+// +build linux
+
+package dockworker
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// processSetup starts the server with a process runtime
+func processSetup(t *testing.T, config ProcessRuntimeConfig) (string, func()) {
+	dir := tempDataDir(t)
+	config.Dir = dir
+	runtime, err := NewProcessRuntime(config)
+	if err != nil {
+		t.Fatalf("Failed to create process runtime: %s", err)
+	}
+	jobURL, cleanup := runtimeSetup(t, runtime, Config{})
+	return jobURL, func() {
+		cleanup()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestProcessRuntimeJob(t *testing.T) {
+	jobURL, cleanup := processSetup(t, ProcessRuntimeConfig{})
+	defer cleanup()
+
+	created := createJob(t, 0, jobURL, `{"image":"ignored","env":{"NAME":"world"},"workdir":"/src","cmds":[
+		["sh","-c","echo hello $NAME > greeting"],
+		["cat","greeting"],
+		["sh","-c","echo oops >&2; exit 3"],
+		["echo","never"]
+	]}`)
+	job := waitForJob(t, jobURL, created.ID, isDone)
+	assert.Equal(t, JobStatusFailed, job.Status)
+	assert.Equal(t, []CmdResult{0, 0, 3}, job.Results)
+	assert.Equal(t, 3, len(job.Containers))
+	assert.Equal(t, 2, len(job.Images))
+	assert.Equal(t, "hello world\noops\n", getLogs(t, 0, jobURL, job.ID), "Files should be passed on to the next command")
+	assert.Equal(t, processRuntimeVersion, job.DockerVersion)
+
+	created = createJob(t, 1, jobURL, `{"image":"ignored","cmds":[["printf","no newline"]]}`)
+	job = waitForJob(t, jobURL, created.ID, isDone)
+	assert.Equal(t, "no newline", getLogs(t, 1, jobURL, job.ID), "Logs should match the output")
+
+	created = createJob(t, 1, jobURL, `{"image":"ignored","cmds":[["notacommand"]]}`)
+	job = waitForJob(t, jobURL, created.ID, isDone)
+	assert.Equal(t, JobStatusError, job.Status)
+}
+
+func TestProcessRuntimeStop(t *testing.T) {
+	jobURL, cleanup := processSetup(t, ProcessRuntimeConfig{})
+	defer cleanup()
+
+	created := createJob(t, 0, jobURL, `{"image":"ignored","cmds":[["sh","-c","echo Sleeping...; exec sleep 30"]]}`)
+	waitForJob(t, jobURL, created.ID, func(job *Job) bool { return strings.Contains(getLogs(t, 0, jobURL, job.ID), "Sleeping") })
+	stopJob(t, 0, jobURL, created.ID)
+
+	job := waitForJob(t, jobURL, created.ID, func(job *Job) bool { return isDone(job) && len(job.Results) == 1 })
+	assert.Equal(t, JobStatusStopped, job.Status)
+	assert.Equal(t, []CmdResult{143}, job.Results, "The process should have been terminated")
+}
+
+func TestProcessRuntimeFiles(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+	runtime, err := NewProcessRuntime(ProcessRuntimeConfig{Dir: dir})
+	assert.NoError(t, err)
+	container, err := runtime.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{Image: "ignored", Cmd: []string{"true"}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	archive := &bytes.Buffer{}
+	tw := tar.NewWriter(archive)
+	tw.WriteHeader(&tar.Header{Name: "src/main.go", Mode: 0644, Size: 12, Typeflag: tar.TypeReg})
+	tw.Write([]byte("package main"))
+	tw.WriteHeader(&tar.Header{Name: "escape", Linkname: dir, Typeflag: tar.TypeSymlink})
+	tw.Close()
+	assert.NoError(t, runtime.UploadToContainer(container.ID, docker.UploadToContainerOptions{InputStream: archive, Path: "/"}))
+
+	downloaded := &bytes.Buffer{}
+	assert.NoError(t, runtime.DownloadFromContainer(container.ID, docker.DownloadFromContainerOptions{Path: "/src/main.go", OutputStream: downloaded}))
+	tr := tar.NewReader(downloaded)
+	header, err := tr.Next()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "main.go", header.Name)
+		data, _ := ioutil.ReadAll(tr)
+		assert.Equal(t, "package main", string(data))
+	}
+
+	err = runtime.DownloadFromContainer(container.ID, docker.DownloadFromContainerOptions{Path: "/escape/roots", OutputStream: &bytes.Buffer{}})
+	assert.Error(t, err, "Symlinks shouldn't lead out of the container")
+	err = runtime.DownloadFromContainer(container.ID, docker.DownloadFromContainerOptions{Path: "/missing", OutputStream: &bytes.Buffer{}})
+	assert.Equal(t, 404, err.(*docker.Error).Status)
+
+	assert.NoError(t, runtime.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID}))
+	roots, _ := ioutil.ReadDir(filepath.Join(dir, "roots"))
+	assert.Equal(t, 0, len(roots), "The root directory should be removed with the container")
+}
+
+func TestProcessRuntimeSandbox(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+	runtime, err := NewProcessRuntime(ProcessRuntimeConfig{Dir: dir, Sandbox: true})
+	assert.NoError(t, err)
+	container, err := runtime.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{Image: "ignored", Cmd: []string{"sh", "-c", "id -u; echo $$"}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if err := runtime.StartContainer(container.ID, nil); err != nil {
+		t.Skipf("User namespaces aren't available: %s", err)
+	}
+	exitCode, err := runtime.WaitContainer(container.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exitCode)
+	output := &bytes.Buffer{}
+	assert.NoError(t, runtime.Logs(docker.LogsOptions{Container: container.ID, OutputStream: output, ErrorStream: output, Stdout: true, Stderr: true}))
+	assert.Equal(t, "0\n1\n", output.String(), "The command should run as root in its own PID namespace")
+}
+
+func TestProcessRuntimeSlowListener(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+	runtime, err := NewProcessRuntime(ProcessRuntimeConfig{Dir: dir})
+	assert.NoError(t, err)
+	// a listener which never reads its events
+	listener := make(chan *docker.APIEvents)
+	runtime.AddEventListener(listener)
+	defer runtime.RemoveEventListener(listener)
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			container, err := runtime.CreateContainer(docker.CreateContainerOptions{
+				Config: &docker.Config{Image: "ignored", Cmd: []string{"true"}},
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NoError(t, runtime.StartContainer(container.ID, nil))
+			runtime.WaitContainer(container.ID)
+			_, err = runtime.CommitContainer(docker.CommitContainerOptions{Container: container.ID})
+			assert.NoError(t, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Containers should keep running while a listener is behind on events")
+	}
+}
+
+func TestProcessRuntimeUnsupported(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+	runtime, err := NewProcessRuntime(ProcessRuntimeConfig{Dir: dir})
+	assert.NoError(t, err)
+
+	cases := []docker.HostConfig{
+		{NetworkMode: NetworkModeNone},
+		{NetworkMode: "build-net"},
+		{Binds: []string{"/srv:/srv"}},
+		{Memory: 64 * 1024 * 1024},
+		{CPUQuota: 50000},
+		{PidsLimit: 100},
+		{Ulimits: []docker.ULimit{{Name: "nofile", Soft: 1024, Hard: 1024}}},
+	}
+	for i, hostConfig := range cases {
+		hc := hostConfig
+		_, err := runtime.CreateContainer(docker.CreateContainerOptions{
+			Config:     &docker.Config{Image: "ignored", Cmd: []string{"true"}},
+			HostConfig: &hc,
+		})
+		assert.Error(t, err, "Case %d: Settings which can't be enforced should be rejected", i)
+	}
+
+	sandboxed, err := NewProcessRuntime(ProcessRuntimeConfig{Dir: dir, Sandbox: true})
+	assert.NoError(t, err)
+	_, err = sandboxed.CreateContainer(docker.CreateContainerOptions{
+		Config:     &docker.Config{Image: "ignored", Cmd: []string{"true"}},
+		HostConfig: &docker.HostConfig{NetworkMode: NetworkModeNone},
+	})
+	assert.NoError(t, err, "Sandboxed commands can run without a network")
+}
@@ -0,0 +1,141 @@
+package dockworker
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// PullPolicy is when the image of a job is pulled before it runs
+type PullPolicy string
+
+const (
+	// PullAlways pulls the image every time the job runs
+	PullAlways PullPolicy = "always"
+	// PullIfNotPresent only pulls the image if it isn't already local
+	PullIfNotPresent PullPolicy = "if-not-present"
+	// PullNever never pulls the image, it has to be local already
+	PullNever PullPolicy = "never"
+)
+
+// parsePullPolicy returns the pull policy, defaulting to always
+// for images which may change under the same name, such as
+// those tagged latest, and if-not-present for the rest
+func parsePullPolicy(v PullPolicy, image string) (PullPolicy, error) {
+	switch v {
+	case PullAlways, PullIfNotPresent, PullNever:
+		return v, nil
+	case "":
+		if _, tag, digest := splitImageName(image); digest == "" && (tag == "" || tag == "latest") {
+			return PullAlways, nil
+		}
+		return PullIfNotPresent, nil
+	}
+	return "", validationErrorf("Invalid pull policy %q, expected always, if-not-present or never", v)
+}
+
+// digestPattern is what the digest of an image pinned by digest looks like
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// validateImageName returns a ValidationError if the image
+// name is missing, or pinned to a digest which isn't valid
+func validateImageName(image string) error {
+	repository, _, digest := splitImageName(image)
+	if repository == "" {
+		return validationErrorf("Image must be set")
+	}
+	if strings.Contains(image, "@") && !digestPattern.MatchString(digest) {
+		return validationErrorf("Digest of image %s must be written as sha256:<64 hex digits>", image)
+	}
+	return nil
+}
+
+// splitImageName splits an image name like registry:5000/app:1.0@sha256:abc
+// into its repository, tag and digest, the last two of which may be empty
+func splitImageName(image string) (repository, tag, digest string) {
+	repository = image
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository, digest = repository[:i], repository[i+1:]
+	}
+	// a colon after the last slash starts the tag,
+	// any before it is the port of the registry
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, tag = repository[:i], repository[i+1:]
+	}
+	return repository, tag, digest
+}
+
+// repoDigest returns the digest the image has in the repository,
+// or an empty string if it didn't come from a registry
+func repoDigest(image *docker.Image, repository string) string {
+	for _, d := range image.RepoDigests {
+		repo := d[:strings.Index(d+"@", "@")]
+		if normalizeRepository(repo) == normalizeRepository(repository) {
+			return d
+		}
+	}
+	return ""
+}
+
+// normalizeRepository drops the parts of the name of a Docker
+// Hub repository which may or may not be written out
+func normalizeRepository(repository string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(repository, dockerHubRegistry+"/"), "library/")
+}
+
+// resolveImage makes sure the image of the job is present, pulling
+// it when the pull policy says to, and records which image it is
+func (jr *jobRunner) resolveImage() error {
+	present := false
+	if jr.job.PullPolicy != PullAlways {
+		_, err := jr.client.InspectImage(jr.job.ImageName)
+		switch err {
+		case nil:
+			present = true
+		case docker.ErrNoSuchImage:
+		default:
+			jr.jobUpdater.UpdateStatus(jr.job, JobStatusError)
+			return err
+		}
+	}
+	if !present {
+		if jr.job.PullPolicy == PullNever {
+			message := fmt.Sprintf("Image %s isn't present and the pull policy is never", jr.job.ImageName)
+			jr.jobUpdater.UpdateMessage(jr.job, message)
+			jr.jobUpdater.UpdateStatus(jr.job, JobStatusError)
+			return errors.New(message)
+		}
+		if err := jr.pullImage(); err != nil {
+			return err
+		}
+	}
+
+	image, err := jr.client.InspectImage(jr.job.ImageName)
+	if err != nil {
+		jr.jobUpdater.UpdateStatus(jr.job, JobStatusError)
+		return err
+	}
+	jr.baseImageConfig = image.Config
+	// run from the image by ID, in case the name
+	// is moved to another one while the job runs
+	jr.prevImage = image
+
+	repository, _, _ := splitImageName(jr.job.ImageName)
+	provenance := Provenance{
+		ImageID:     ImageName(image.ID),
+		ImageDigest: repoDigest(image, repository),
+	}
+	info, err := jr.client.Info()
+	if err != nil {
+		// not knowing the daemon isn't worth failing the job over
+		log.Warnf("Error getting Docker info for job %d: %s", jr.job.ID, err)
+	} else {
+		provenance.DockerVersion = info.ServerVersion
+		provenance.Host = info.Name
+	}
+	return jr.jobUpdater.SetProvenance(jr.job, provenance)
+}
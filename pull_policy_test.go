@@ -0,0 +1,70 @@
+package dockworker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePullPolicy(t *testing.T) {
+	defaults := map[string]PullPolicy{
+		"alpine":                        PullAlways,
+		"alpine:latest":                 PullAlways,
+		"alpine:3.4":                    PullIfNotPresent,
+		"localhost:5000/app":            PullAlways,
+		"localhost:5000/app:1.0":        PullIfNotPresent,
+		"alpine@sha256:abc":             PullIfNotPresent,
+		"alpine:latest@sha256:abc":      PullIfNotPresent,
+		"registry.example.com/team/app": PullAlways,
+	}
+	for image, expected := range defaults {
+		policy, err := parsePullPolicy("", image)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, policy, image)
+	}
+
+	policy, err := parsePullPolicy(PullNever, "alpine")
+	assert.NoError(t, err)
+	assert.Equal(t, PullNever, policy)
+
+	_, err = parsePullPolicy("sometimes", "alpine")
+	_, ok := err.(ValidationError)
+	assert.True(t, ok, "Invalid pull policies should be rejected")
+}
+
+func TestSplitImageName(t *testing.T) {
+	repository, tag, digest := splitImageName("localhost:5000/team/app:1.0@sha256:abc")
+	assert.Equal(t, "localhost:5000/team/app", repository)
+	assert.Equal(t, "1.0", tag)
+	assert.Equal(t, "sha256:abc", digest)
+
+	repository, tag, digest = splitImageName("localhost:5000/app")
+	assert.Equal(t, "localhost:5000/app", repository)
+	assert.Equal(t, "", tag)
+	assert.Equal(t, "", digest)
+}
+
+func TestRepoDigest(t *testing.T) {
+	image := &docker.Image{RepoDigests: []string{
+		"registry.example.com/app@sha256:abc",
+		"docker.io/library/alpine@sha256:def",
+	}}
+	assert.Equal(t, "docker.io/library/alpine@sha256:def", repoDigest(image, "alpine"))
+	assert.Equal(t, "registry.example.com/app@sha256:abc", repoDigest(image, "registry.example.com/app"))
+	assert.Equal(t, "", repoDigest(image, "app"))
+	assert.Equal(t, "", repoDigest(&docker.Image{}, "alpine"), "Local images have no digest")
+}
+
+func TestValidateImageName(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	assert.NoError(t, validateImageName("alpine"))
+	assert.NoError(t, validateImageName("alpine@"+digest))
+	assert.NoError(t, validateImageName("localhost:5000/app:1.0@"+digest))
+
+	for _, image := range []string{"", "@" + digest, "alpine@sha256:abc", "alpine@" + strings.ToUpper(digest)} {
+		_, ok := validateImageName(image).(ValidationError)
+		assert.True(t, ok, "%q should be rejected", image)
+	}
+}
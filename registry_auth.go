@@ -0,0 +1,157 @@
+package dockworker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// EnvRegistryAuth is the environment variable which sets the Docker
+	// config.json style file of the credentials used for every job
+	EnvRegistryAuth = "DOCKWORKER_REGISTRY_AUTH"
+	// EnvRegistryCredentialsDir is the environment variable which sets
+	// the directory of the named credentials jobs may refer to, each
+	// a config.json style file named after the credentials
+	EnvRegistryCredentialsDir = "DOCKWORKER_REGISTRY_CREDENTIALS_DIR"
+
+	// the registry images without a hostname come from
+	dockerHubRegistry = "docker.io"
+)
+
+// RegistryAuth looks up the credentials for registry operations.
+// The credentials never leave it other than to go to Docker, jobs
+// only refer to named credentials.
+type RegistryAuth interface {
+	// ForImage returns the credentials for the registry of the image,
+	// from the named credentials if there are any, or else from the
+	// ones used for every job. They're empty if there are none.
+	ForImage(image, credentials string) (docker.AuthConfiguration, error)
+	// HasCredentials returns whether there are named credentials with the name
+	HasCredentials(name string) bool
+}
+
+// NewRegistryAuth returns a RegistryAuth reading credentials from the
+// file and the named credentials from the directory, either of which may
+// be empty. The files are read every time so credentials can be rotated.
+func NewRegistryAuth(file, credentialsDir string) RegistryAuth {
+	return registryAuth{
+		file:           file,
+		credentialsDir: credentialsDir,
+	}
+}
+
+type registryAuth struct {
+	file           string
+	credentialsDir string
+}
+
+// dockerConfig is the part of a Docker config.json holding credentials
+type dockerConfig struct {
+	Auths map[string]dockerConfigAuth `json:"auths"`
+}
+
+type dockerConfigAuth struct {
+	// Auth is the base64 encoded username:password
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+func (ra registryAuth) ForImage(image, credentials string) (docker.AuthConfiguration, error) {
+	file := ra.file
+	if credentials != "" {
+		if !ra.HasCredentials(credentials) {
+			return docker.AuthConfiguration{}, fmt.Errorf("No registry credentials named %s", credentials)
+		}
+		file = ra.credentialsFile(credentials)
+	}
+	if file == "" {
+		return docker.AuthConfiguration{}, nil
+	}
+	config, err := readDockerConfig(file)
+	if err != nil {
+		return docker.AuthConfiguration{}, err
+	}
+	registry := imageRegistry(image)
+	for address, auth := range config.Auths {
+		if normalizeRegistry(address) == registry {
+			return auth.configuration(address)
+		}
+	}
+	return docker.AuthConfiguration{}, nil
+}
+
+func (ra registryAuth) HasCredentials(name string) bool {
+	if ra.credentialsDir == "" || !namePattern.MatchString(name) {
+		return false
+	}
+	_, err := os.Stat(ra.credentialsFile(name))
+	return err == nil
+}
+
+func (ra registryAuth) credentialsFile(name string) string {
+	return filepath.Join(ra.credentialsDir, name+".json")
+}
+
+func readDockerConfig(file string) (dockerConfig, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return dockerConfig{}, err
+	}
+	defer f.Close()
+	config := dockerConfig{}
+	if err := json.NewDecoder(f).Decode(&config); err != nil {
+		// don't echo the file, it has credentials in it
+		return dockerConfig{}, fmt.Errorf("Failed to read registry credentials from %s", file)
+	}
+	return config, nil
+}
+
+func (auth dockerConfigAuth) configuration(address string) (docker.AuthConfiguration, error) {
+	config := docker.AuthConfiguration{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		Email:         auth.Email,
+		ServerAddress: address,
+	}
+	if auth.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return docker.AuthConfiguration{}, fmt.Errorf("Invalid auth for registry %s", address)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return docker.AuthConfiguration{}, fmt.Errorf("Invalid auth for registry %s", address)
+		}
+		config.Username, config.Password = parts[0], parts[1]
+	}
+	return config, nil
+}
+
+// imageRegistry returns the hostname of the registry the image comes from
+func imageRegistry(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0]
+	}
+	return dockerHubRegistry
+}
+
+// normalizeRegistry returns the hostname of a registry address
+// in a config.json, which may be a URL like https://index.docker.io/v1/
+func normalizeRegistry(address string) string {
+	address = strings.TrimPrefix(strings.TrimPrefix(address, "https://"), "http://")
+	address = strings.SplitN(address, "/", 2)[0]
+	switch address {
+	case "index.docker.io", "registry-1.docker.io":
+		return dockerHubRegistry
+	}
+	return address
+}
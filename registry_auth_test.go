@@ -0,0 +1,66 @@
+package dockworker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageRegistry(t *testing.T) {
+	cases := map[string]string{
+		"alpine":                            dockerHubRegistry,
+		"library/alpine:3.4":                dockerHubRegistry,
+		"registry.example.com/team/app:1.0": "registry.example.com",
+		"localhost:5000/app":                "localhost:5000",
+		"localhost/app":                     "localhost",
+	}
+	for image, registry := range cases {
+		assert.Equal(t, registry, imageRegistry(image), image)
+	}
+	assert.Equal(t, dockerHubRegistry, normalizeRegistry("https://index.docker.io/v1/"))
+	assert.Equal(t, "registry.example.com", normalizeRegistry("registry.example.com"))
+}
+
+func TestRegistryAuth(t *testing.T) {
+	dir := tempDataDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(file, []byte(`{"auths":{
+		"https://index.docker.io/v1/":{"auth":"aHViLXVzZXI6aHViLXBhc3M="},
+		"registry.example.com":{"username":"shared","password":"secret"}}}`), 0600)
+	credentialsDir := filepath.Join(dir, "credentials")
+	os.Mkdir(credentialsDir, 0700)
+	ioutil.WriteFile(filepath.Join(credentialsDir, "team-a.json"), []byte(`{"auths":{
+		"registry.example.com":{"auth":"dGVhbS1hOnRlYW0tcGFzcw=="}}}`), 0600)
+	auth := NewRegistryAuth(file, credentialsDir)
+
+	config, err := auth.ForImage("alpine", "")
+	assert.NoError(t, err)
+	assert.Equal(t, docker.AuthConfiguration{Username: "hub-user", Password: "hub-pass", ServerAddress: "https://index.docker.io/v1/"}, config)
+
+	config, err = auth.ForImage("registry.example.com/app", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "shared", config.Username)
+
+	config, err = auth.ForImage("registry.example.com/app", "team-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "team-a", config.Username, "Named credentials should be used instead")
+
+	config, err = auth.ForImage("other.example.com/app", "team-a")
+	assert.NoError(t, err)
+	assert.Equal(t, docker.AuthConfiguration{}, config, "Credentials should only go to their registry")
+
+	assert.True(t, auth.HasCredentials("team-a"))
+	assert.False(t, auth.HasCredentials("team-b"))
+	assert.False(t, auth.HasCredentials("../config"), "Names shouldn't reach outside the credentials directory")
+	_, err = auth.ForImage("alpine", "team-b")
+	assert.Error(t, err)
+
+	config, err = NewRegistryAuth("", "").ForImage("alpine", "")
+	assert.NoError(t, err)
+	assert.Equal(t, docker.AuthConfiguration{}, config)
+}
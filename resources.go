@@ -0,0 +1,237 @@
+package dockworker
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// EnvDefaultResourcePrefix is the prefix of the environment variables
+	// which set the resources of jobs which don't ask for any, for example
+	// DOCKWORKER_DEFAULT_MEMORY
+	EnvDefaultResourcePrefix = "DOCKWORKER_DEFAULT_"
+	// EnvMaxResourcePrefix is the prefix of the environment variables which
+	// set the most resources a job may ask for, for example DOCKWORKER_MAX_MEMORY
+	EnvMaxResourcePrefix = "DOCKWORKER_MAX_"
+)
+
+// Resources are the limits put on the containers of a job. Zero means no limit.
+type Resources struct {
+	// Memory is in bytes
+	Memory int64 `json:"memory,omitempty"`
+	// MemorySwap is the memory plus swap in bytes,
+	// -1 means unlimited swap
+	MemorySwap int64 `json:"memory_swap,omitempty"`
+	// CPUShares is the weight of the containers
+	// against others when the CPUs are busy
+	CPUShares int64 `json:"cpu_shares,omitempty"`
+	// CPUQuota is the microseconds of CPU time the containers
+	// may use in every 100ms, so 50000 is half a CPU
+	CPUQuota  int64    `json:"cpu_quota,omitempty"`
+	PidsLimit int64    `json:"pids_limit,omitempty"`
+	Ulimits   []Ulimit `json:"ulimits,omitempty"`
+}
+
+// Ulimit is a limit set with setrlimit, such as nofile
+type Ulimit struct {
+	Name string `json:"name"`
+	Soft int64  `json:"soft"`
+	Hard int64  `json:"hard"`
+}
+
+// ResourceLimits are the resources jobs get when they don't ask
+// for any, and the most they may ask for. A job which doesn't ask
+// for a resource which has a maximum but no default gets the maximum.
+type ResourceLimits struct {
+	Default Resources
+	Max     Resources
+}
+
+// resourcesFromEnv reads the resources from the environment variables
+// with the prefix, exiting if any are invalid
+func resourcesFromEnv(prefix string) Resources {
+	ulimits, err := parseUlimits(os.Getenv(prefix + "ULIMITS"))
+	if err != nil {
+		log.Fatalf("Invalid value for %sULIMITS: %s", prefix, err)
+	}
+	return Resources{
+		Memory:     bytesFromEnv(prefix + "MEMORY"),
+		MemorySwap: bytesFromEnv(prefix + "MEMORY_SWAP"),
+		CPUShares:  int64(intFromEnv(prefix+"CPU_SHARES", 0)),
+		CPUQuota:   int64(intFromEnv(prefix+"CPU_QUOTA", 0)),
+		PidsLimit:  int64(intFromEnv(prefix+"PIDS_LIMIT", 0)),
+		Ulimits:    ulimits,
+	}
+}
+
+func bytesFromEnv(name string) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	b, err := parseBytes(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s, expected a number of bytes: %s", name, v)
+	}
+	return b
+}
+
+// parseBytes parses a number of bytes, which
+// may end in k, m or g for binary multiples
+func parseBytes(v string) (int64, error) {
+	multiplier := int64(1)
+	switch strings.ToLower(v[len(v)-1:]) {
+	case "k":
+		multiplier = 1 << 10
+	case "m":
+		multiplier = 1 << 20
+	case "g":
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
+// parseUlimits parses ulimits written like name=soft:hard,
+// or name=limit for the same soft and hard limit, separated by commas
+func parseUlimits(v string) ([]Ulimit, error) {
+	ulimits := []Ulimit{}
+	if v == "" {
+		return ulimits, nil
+	}
+	for _, s := range strings.Split(v, ",") {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("expected name=soft:hard, got %q", s)
+		}
+		limits := strings.SplitN(parts[1], ":", 2)
+		soft, err := strconv.ParseInt(limits[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid soft limit in %q", s)
+		}
+		hard := soft
+		if len(limits) == 2 {
+			if hard, err = strconv.ParseInt(limits[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid hard limit in %q", s)
+			}
+		}
+		ulimits = append(ulimits, Ulimit{Name: parts[0], Soft: soft, Hard: hard})
+	}
+	return ulimits, nil
+}
+
+// validateResources returns a ValidationError if the resources a job asks for make no sense
+func validateResources(r Resources) error {
+	if r.Memory < 0 || r.CPUShares < 0 || r.CPUQuota < 0 || r.PidsLimit < 0 {
+		return validationErrorf("Resources must not be negative")
+	}
+	if r.MemorySwap < -1 {
+		return validationErrorf("Memory swap must be -1 for unlimited swap, or not negative")
+	}
+	if r.CPUQuota > 0 && r.CPUQuota < 1000 {
+		return validationErrorf("CPU quota must be at least 1000 microseconds")
+	}
+	for _, ulimit := range r.Ulimits {
+		if ulimit.Name == "" || ulimit.Soft < 0 || ulimit.Soft > ulimit.Hard {
+			return validationErrorf("Ulimit %q must have a soft limit no greater than its hard limit", ulimit.Name)
+		}
+	}
+	return nil
+}
+
+// apply returns the resources with the defaults filled in,
+// or a ValidationError if they're over the maximums
+func (limits ResourceLimits) apply(r Resources) (Resources, error) {
+	if err := validateResources(r); err != nil {
+		return Resources{}, err
+	}
+	checks := []struct {
+		name              string
+		value             *int64
+		defaultValue, max int64
+	}{
+		{"Memory", &r.Memory, limits.Default.Memory, limits.Max.Memory},
+		{"Memory swap", &r.MemorySwap, limits.Default.MemorySwap, limits.Max.MemorySwap},
+		{"CPU shares", &r.CPUShares, limits.Default.CPUShares, limits.Max.CPUShares},
+		{"CPU quota", &r.CPUQuota, limits.Default.CPUQuota, limits.Max.CPUQuota},
+		{"PIDs limit", &r.PidsLimit, limits.Default.PidsLimit, limits.Max.PidsLimit},
+	}
+	for _, check := range checks {
+		if *check.value == 0 {
+			*check.value = check.defaultValue
+		}
+		if *check.value == 0 {
+			*check.value = check.max
+		}
+		if check.max > 0 && (*check.value > check.max || *check.value < 0) {
+			return Resources{}, validationErrorf("%s must be at most %d", check.name, check.max)
+		}
+	}
+	if r.MemorySwap != 0 && r.Memory == 0 {
+		return Resources{}, validationErrorf("Memory swap can only be set along with memory")
+	}
+	if r.MemorySwap > 0 && r.MemorySwap < r.Memory {
+		return Resources{}, validationErrorf("Memory swap must be at least the memory, it includes it")
+	}
+
+	ulimits := append([]Ulimit{}, r.Ulimits...)
+	for _, defaults := range [][]Ulimit{limits.Default.Ulimits, limits.Max.Ulimits} {
+		for _, ulimit := range defaults {
+			if findUlimit(ulimits, ulimit.Name) == nil {
+				ulimits = append(ulimits, ulimit)
+			}
+		}
+	}
+	for _, max := range limits.Max.Ulimits {
+		if ulimit := findUlimit(ulimits, max.Name); ulimit.Hard > max.Hard {
+			return Resources{}, validationErrorf("Ulimit %s must be at most %d", max.Name, max.Hard)
+		}
+	}
+	r.Ulimits = nil
+	if len(ulimits) > 0 {
+		r.Ulimits = ulimits
+	}
+	return r, nil
+}
+
+func findUlimit(ulimits []Ulimit, name string) *Ulimit {
+	for i := range ulimits {
+		if ulimits[i].Name == name {
+			return &ulimits[i]
+		}
+	}
+	return nil
+}
+
+// isZero returns whether there are no limits at all
+func (r Resources) isZero() bool {
+	return r.Memory == 0 && r.MemorySwap == 0 && r.CPUShares == 0 &&
+		r.CPUQuota == 0 && r.PidsLimit == 0 && len(r.Ulimits) == 0
+}
+
+// apply sets the limits in the host config of a container
+func (r Resources) apply(hostConfig *docker.HostConfig) {
+	hostConfig.Memory = r.Memory
+	hostConfig.MemorySwap = r.MemorySwap
+	hostConfig.CPUShares = r.CPUShares
+	hostConfig.CPUQuota = r.CPUQuota
+	hostConfig.PidsLimit = r.PidsLimit
+	for _, ulimit := range r.Ulimits {
+		hostConfig.Ulimits = append(hostConfig.Ulimits, docker.ULimit{
+			Name: ulimit.Name,
+			Soft: ulimit.Soft,
+			Hard: ulimit.Hard,
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package dockworker
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUlimits(t *testing.T) {
+	ulimits, err := parseUlimits("nofile=1024:4096,nproc=512")
+	assert.NoError(t, err)
+	assert.Equal(t, []Ulimit{{"nofile", 1024, 4096}, {"nproc", 512, 512}}, ulimits)
+
+	for _, v := range []string{"nofile", "=1", "nofile=a", "nofile=1:b"} {
+		_, err := parseUlimits(v)
+		assert.Error(t, err, "%q should be invalid", v)
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	cases := map[string]int64{"100": 100, "2k": 2048, "512m": 512 << 20, "1G": 1 << 30}
+	for v, expected := range cases {
+		b, err := parseBytes(v)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, b, "%q", v)
+	}
+	_, err := parseBytes("lots")
+	assert.Error(t, err)
+}
+
+func TestResourceLimits(t *testing.T) {
+	limits := ResourceLimits{
+		Default: Resources{Memory: 256 << 20, Ulimits: []Ulimit{{"nofile", 1024, 1024}}},
+		Max:     Resources{Memory: 1 << 30, PidsLimit: 100, Ulimits: []Ulimit{{"nofile", 4096, 4096}}},
+	}
+
+	r, err := limits.apply(Resources{})
+	assert.NoError(t, err)
+	assert.Equal(t, Resources{Memory: 256 << 20, PidsLimit: 100, Ulimits: []Ulimit{{"nofile", 1024, 1024}}}, r,
+		"Jobs should get the defaults, or the maximums where there are none")
+
+	r, err = limits.apply(Resources{Memory: 512 << 20, MemorySwap: -1, CPUShares: 512, Ulimits: []Ulimit{{"nofile", 2048, 4096}}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(512<<20), r.Memory)
+	assert.Equal(t, int64(-1), r.MemorySwap)
+	assert.Equal(t, []Ulimit{{"nofile", 2048, 4096}}, r.Ulimits)
+
+	invalid := []Resources{
+		{Memory: 2 << 30},
+		{PidsLimit: 101},
+		{Ulimits: []Ulimit{{"nofile", 1024, 8192}}},
+		{Ulimits: []Ulimit{{"nofile", 2048, 1024}}},
+		{Memory: 512 << 20, MemorySwap: 256 << 20},
+		{CPUQuota: -1},
+		{CPUQuota: 10},
+	}
+	for i, resources := range invalid {
+		_, err := limits.apply(resources)
+		_, ok := err.(ValidationError)
+		assert.True(t, ok, "Case %d: %+v should be rejected", i, resources)
+	}
+
+	r, err = ResourceLimits{}.apply(Resources{})
+	assert.NoError(t, err)
+	assert.True(t, r.isZero(), "There should be no limits unless some are configured")
+}
+
+func TestResourcesHostConfig(t *testing.T) {
+	hostConfig := &docker.HostConfig{}
+	Resources{Memory: 1 << 20, CPUQuota: 50000, Ulimits: []Ulimit{{"nproc", 10, 20}}}.apply(hostConfig)
+	assert.Equal(t, int64(1<<20), hostConfig.Memory)
+	assert.Equal(t, int64(50000), hostConfig.CPUQuota)
+	assert.Equal(t, []docker.ULimit{{Name: "nproc", Soft: 10, Hard: 20}}, hostConfig.Ulimits)
+}
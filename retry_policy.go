@@ -0,0 +1,91 @@
+package dockworker
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// DefaultBackoffMultiplier is how much longer each retry
+	// waits than the one before if no multiplier is given
+	DefaultBackoffMultiplier = 2
+)
+
+// RetryPolicy decides whether a job which didn't
+// succeed is run again, and when
+type RetryPolicy struct {
+	// MaxAttempts is the most times the job runs, including the first
+	MaxAttempts int `json:"max_attempts"`
+	// Backoff is the number of seconds to wait before the first retry
+	Backoff int `json:"backoff"`
+	// BackoffMultiplier is how much longer each retry waits than the one before
+	BackoffMultiplier float64 `json:"backoff_multiplier"`
+	// Statuses are the statuses a job is retried after,
+	// failed and error if none are given
+	Statuses []JobStatus `json:"statuses"`
+	// ExitCodes limits retrying failed jobs to those whose
+	// last command exited with one of these codes
+	ExitCodes []int `json:"exit_codes"`
+	// WebhookEachAttempt sends the webhook after every attempt,
+	// instead of only once the job has its final outcome
+	WebhookEachAttempt bool `json:"webhook_each_attempt"`
+}
+
+// retryableStatuses are the statuses a job can be retried after
+var retryableStatuses = []JobStatus{JobStatusFailed, JobStatusError, JobStatusTimedOut}
+
+// nextRetry returns how long to wait before retrying the
+// job, or false if it shouldn't be retried
+func (p RetryPolicy) nextRetry(job Job) (time.Duration, bool) {
+	attempt := len(job.Attempts) + 1
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	statuses := p.Statuses
+	if len(statuses) == 0 {
+		statuses = []JobStatus{JobStatusFailed, JobStatusError}
+	}
+	if !containsStatus(statuses, job.Status) {
+		return 0, false
+	}
+	if job.Status == JobStatusFailed && len(p.ExitCodes) > 0 {
+		if len(job.Results) == 0 || !containsExitCode(p.ExitCodes, int(job.Results[len(job.Results)-1])) {
+			return 0, false
+		}
+	}
+
+	multiplier := p.BackoffMultiplier
+	if multiplier == 0 {
+		multiplier = DefaultBackoffMultiplier
+	}
+	backoff := float64(p.Backoff) * math.Pow(multiplier, float64(attempt-1))
+	return time.Duration(backoff * float64(time.Second)), true
+}
+
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts < 0 {
+		return validationErrorf("Retry max attempts must not be negative")
+	}
+	if p.Backoff < 0 {
+		return validationErrorf("Retry backoff must not be negative")
+	}
+	if p.BackoffMultiplier != 0 && p.BackoffMultiplier < 1 {
+		return validationErrorf("Retry backoff multiplier must be at least 1")
+	}
+	for _, status := range p.Statuses {
+		if !containsStatus(retryableStatuses, status) {
+			return validationErrorf("Jobs can't be retried after status %s", status)
+		}
+	}
+	return nil
+}
+
+func containsExitCode(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package dockworker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyNextRetry(t *testing.T) {
+	cases := []struct {
+		policy RetryPolicy
+		job    Job
+		retry  bool
+		delay  time.Duration
+	}{
+		// failed jobs are retried by default
+		{RetryPolicy{MaxAttempts: 3, Backoff: 2}, Job{Status: JobStatusFailed}, true, 2 * time.Second},
+		// the backoff grows with each attempt
+		{RetryPolicy{MaxAttempts: 3, Backoff: 2}, Job{Status: JobStatusError, Attempts: []Attempt{Attempt{}}}, true, 4 * time.Second},
+		{RetryPolicy{MaxAttempts: 4, Backoff: 2, BackoffMultiplier: 3}, Job{Status: JobStatusError, Attempts: []Attempt{Attempt{}, Attempt{}}}, true, 18 * time.Second},
+		// no more attempts left
+		{RetryPolicy{MaxAttempts: 2}, Job{Status: JobStatusFailed, Attempts: []Attempt{Attempt{}}}, false, 0},
+		// successful and stopped jobs are never retried
+		{RetryPolicy{MaxAttempts: 3}, Job{Status: JobStatusSuccessful}, false, 0},
+		{RetryPolicy{MaxAttempts: 3}, Job{Status: JobStatusStopped}, false, 0},
+		// timed out jobs are only retried when asked for
+		{RetryPolicy{MaxAttempts: 3}, Job{Status: JobStatusTimedOut}, false, 0},
+		{RetryPolicy{MaxAttempts: 3, Statuses: []JobStatus{JobStatusTimedOut}}, Job{Status: JobStatusTimedOut}, true, 0},
+		// failed jobs can be limited to certain exit codes
+		{RetryPolicy{MaxAttempts: 3, ExitCodes: []int{75}}, Job{Status: JobStatusFailed, Results: []CmdResult{0, 75}}, true, 0},
+		{RetryPolicy{MaxAttempts: 3, ExitCodes: []int{75}}, Job{Status: JobStatusFailed, Results: []CmdResult{0, 1}}, false, 0},
+	}
+	for i, tc := range cases {
+		delay, retry := tc.policy.nextRetry(tc.job)
+		assert.Equal(t, tc.retry, retry, "Case %d: Retry should match", i)
+		assert.Equal(t, tc.delay, delay, "Case %d: Delay should match", i)
+	}
+}
@@ -0,0 +1,58 @@
+package dockworker
+
+import (
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// EnvRuntime is the environment variable which sets what runs
+	// the commands of jobs, docker or process
+	EnvRuntime = "DOCKWORKER_RUNTIME"
+
+	// RuntimeDocker runs commands in Docker containers
+	RuntimeDocker = "docker"
+	// RuntimeProcess runs commands as local processes
+	RuntimeProcess = "process"
+)
+
+// Runtime is what runs the containers of jobs. It's described
+// in go-dockerclient's terms, which other runtimes translate from.
+type Runtime interface {
+	Ping() error
+	Info() (*docker.DockerInfo, error)
+
+	PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
+	InspectImage(name string) (*docker.Image, error)
+	RemoveImage(name string) error
+
+	CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error)
+	StartContainer(id string, hostConfig *docker.HostConfig) error
+	StopContainer(id string, timeout uint) error
+	WaitContainer(id string) (int, error)
+	InspectContainer(id string) (*docker.Container, error)
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	CommitContainer(opts docker.CommitContainerOptions) (*docker.Image, error)
+	RemoveContainer(opts docker.RemoveContainerOptions) error
+	UploadToContainer(id string, opts docker.UploadToContainerOptions) error
+	DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error
+	Logs(opts docker.LogsOptions) error
+
+	ListVolumes(opts docker.ListVolumesOptions) ([]docker.Volume, error)
+	RemoveVolume(name string) error
+
+	// AddEventListener sends the events of containers to the
+	// listener until it's removed with RemoveEventListener
+	AddEventListener(listener chan<- *docker.APIEvents) error
+	RemoveEventListener(listener chan *docker.APIEvents) error
+}
+
+// NewDockerRuntime returns a Runtime running containers with the Docker daemon of the client
+func NewDockerRuntime(client *docker.Client) Runtime {
+	return dockerRuntime{client}
+}
+
+// dockerRuntime is a Runtime for a Docker daemon, the
+// client already has every method with the same signature
+type dockerRuntime struct {
+	*docker.Client
+}
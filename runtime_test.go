@@ -0,0 +1,413 @@
+package dockworker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// runtimeSetup starts the server with the runtime and config, returning
+// the URL of the jobs and a function which cleans up after the test. The
+// data directory is a new one unless the config has one.
+func runtimeSetup(t *testing.T, runtime Runtime, config Config) (string, func()) {
+	if config.DataDir == "" {
+		config.DataDir = tempDataDir(t)
+	}
+	dir := config.DataDir
+	if config.EventHistory == 0 {
+		config.EventHistory = DefaultEventHistory
+	}
+	jobAPI, adminAPI := initAPIs(config, runtime)
+	wsContainer := restful.NewContainer()
+	jobAPI.Register(wsContainer)
+	adminAPI.Register(wsContainer)
+	ts := httptest.NewServer(wsContainer)
+	return ts.URL + "/jobs", func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// fakeSetup starts the server with a fake runtime
+func fakeSetup(t *testing.T) (string, *fakeRuntime, func()) {
+	return fakeSetupWithConfig(t, Config{})
+}
+
+func fakeSetupWithConfig(t *testing.T, config Config) (string, *fakeRuntime, func()) {
+	fr := newFakeRuntime()
+	fr.addImage("alpine", nil, nil)
+	fr.addImage("alpine:3.4", nil, map[string][]byte{"/etc/alpine-release": []byte("3.4.6\n")})
+	jobURL, cleanup := runtimeSetup(t, fr, config)
+	return jobURL, fr, cleanup
+}
+
+// restartSetup starts the server as if it was restarted while
+// the job was running, with the job stored in its data directory
+func restartSetup(t *testing.T, fr *fakeRuntime, job Job) (string, JobID, func()) {
+	dir := tempDataDir(t)
+	store, err := NewFileJobStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create job store: %s", err)
+	}
+	added, _ := store.Add(job)
+	job.ID = added.ID
+	store.Update(job)
+	jobURL, cleanup := runtimeSetup(t, fr, Config{DataDir: dir})
+	return jobURL, job.ID, cleanup
+}
+
+// waitForJob polls the job until the condition holds for it
+func waitForJob(t *testing.T, jobURL string, ID JobID, condition func(job *Job) bool) *Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job := getJob(t, 0, jobURL, ID)
+		if condition(job) {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Waited too long for job %d, it's %+v", ID, job)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func isDone(job *Job) bool {
+	return job.Status != JobStatusQueued && job.Status != JobStatusRunning
+}
+
+func TestFakeRuntimeJob(t *testing.T) {
+	jobURL, _, cleanup := fakeSetup(t)
+	defer cleanup()
+
+	created := createJob(t, 0, jobURL, `{"image":"alpine:3.4","cmds":[
+		["write","/greeting","hello"],
+		["cat","/greeting"],
+		["cat","/etc/alpine-release"]
+	]}`)
+	job := waitForJob(t, jobURL, created.ID, isDone)
+	assert.Equal(t, JobStatusSuccessful, job.Status)
+	assert.Equal(t, []CmdResult{0, 0, 0}, job.Results)
+	assert.Equal(t, 3, len(job.Containers))
+	assert.Equal(t, 3, len(job.Images))
+	assert.Equal(t, "hello\n3.4.6\n", getLogs(t, 0, jobURL, job.ID), "Files should be carried over by the committed images")
+
+	lines := getLogLines(t, 0, fmt.Sprintf("%s/%d/logs?format=ndjson", jobURL, job.ID))
+	if assert.Equal(t, 2, len(lines)) {
+		assert.Equal(t, 2, lines[1].Cmd)
+		assert.False(t, lines[1].Time.IsZero(), "Lines should have a timestamp")
+	}
+
+	assert.NotEmpty(t, job.ImageID)
+	assert.True(t, strings.HasPrefix(job.ImageDigest, "alpine@sha256:"), "Digest %s should be recorded", job.ImageDigest)
+	assert.Equal(t, "fake", job.DockerVersion)
+	assert.Equal(t, "fake-host", job.Host)
+}
+
+func TestFakeRuntimeFailedJob(t *testing.T) {
+	jobURL, _, cleanup := fakeSetup(t)
+	defer cleanup()
+
+	created := createJob(t, 0, jobURL, `{"image":"alpine","cmds":[["echo","one"],["cat","/missing"],["echo","never"]]}`)
+	job := waitForJob(t, jobURL, created.ID, isDone)
+	assert.Equal(t, JobStatusFailed, job.Status)
+	assert.Equal(t, []CmdResult{0, 1}, job.Results)
+	assert.Equal(t, 2, len(job.Containers))
+	assert.Equal(t, 1, len(job.Images))
+	assert.Equal(t, "one\ncat: /missing: No such file or directory\n", getLogs(t, 0, jobURL, job.ID))
+}
+
+func TestFakeRuntimeErrors(t *testing.T) {
+	jobURL, fr, cleanup := fakeSetup(t)
+	defer cleanup()
+
+	fr.failNext("PullImage", &docker.Error{Status: 500, Message: "registry unavailable"})
+	cases := []struct {
+		body    string
+		message string
+	}{
+		{`{"image":"alpine","cmds":[["echo","hi"]]}`, ""},
+		{`{"image":"alpine","cmds":[["notacommand"]]}`, ""},
+		{`{"image":"busybox","cmds":[["echo","hi"]]}`, ""},
+		{`{"image":"busybox","pull_policy":"never","cmds":[["echo","hi"]]}`, "Image busybox isn't present and the pull policy is never"},
+	}
+	for i, tc := range cases {
+		created := createJob(t, i, jobURL, tc.body)
+		job := waitForJob(t, jobURL, created.ID, isDone)
+		assert.Equal(t, JobStatusError, job.Status, "Case %d: Status should match", i)
+		assert.Equal(t, tc.message, job.Message, "Case %d: Message should match", i)
+	}
+}
+
+func TestFakeRuntimeOOM(t *testing.T) {
+	jobURL, _, cleanup := fakeSetup(t)
+	defer cleanup()
+
+	created := createJob(t, 0, jobURL, `{"image":"alpine","cmds":[["oom"]]}`)
+	job := waitForJob(t, jobURL, created.ID, isDone)
+	assert.Equal(t, JobStatusFailed, job.Status)
+	assert.Equal(t, []CmdResult{137}, job.Results)
+	assert.Equal(t, "Command 0 was killed for running out of memory", job.Message)
+}
+
+func TestFakeRuntimeStop(t *testing.T) {
+	jobURL, fr, cleanup := fakeSetup(t)
+	defer cleanup()
+
+	started := make(chan bool, 1)
+	fr.programs["block"] = func(p *fakeProcess) int {
+		started <- true
+		<-p.Stopped
+		return 137
+	}
+	created := createJob(t, 0, jobURL, `{"image":"alpine","cmds":[["echo","Sleeping..."],["block"],["echo","never"]]}`)
+	<-started
+	stopJob(t, 0, jobURL, created.ID)
+
+	job := waitForJob(t, jobURL, created.ID, isDone)
+	assert.Equal(t, JobStatusStopped, job.Status)
+	job = waitForJob(t, jobURL, created.ID, func(job *Job) bool { return len(job.Results) == 2 })
+	assert.Equal(t, []CmdResult{0, 137}, job.Results)
+	assert.Equal(t, 2, len(job.Containers))
+	assert.Equal(t, 1, len(job.Images))
+	assert.Equal(t, "Sleeping...\n", getLogs(t, 0, jobURL, job.ID))
+}
+
+// TestFakeRuntimeStopWhileStarting stops queued jobs while the jobs
+// before them end, so they're stopped as they're being started
+func TestFakeRuntimeStopWhileStarting(t *testing.T) {
+	jobURL, fr, cleanup := fakeSetupWithConfig(t, Config{MaxRunningJobs: 1})
+	defer cleanup()
+
+	fr.programs["block"] = func(p *fakeProcess) int {
+		<-p.Stopped
+		return 137
+	}
+	IDs := []JobID{}
+	for i := 0; i < 10; i++ {
+		created := createJob(t, i, jobURL, `{"image":"alpine","cmds":[["block"]]}`)
+		IDs = append(IDs, created.ID)
+	}
+	for i, ID := range IDs {
+		stopJob(t, i, jobURL, ID)
+	}
+	for _, ID := range IDs {
+		job := waitForJob(t, jobURL, ID, isDone)
+		assert.Equal(t, JobStatusStopped, job.Status, "Job %d should be stopped", ID)
+	}
+}
+
+// TestFakeRuntimeStopAfterExit stops a job after its container
+// exited, but before the runner heard about it dying
+func TestFakeRuntimeStopAfterExit(t *testing.T) {
+	jobURL, fr, cleanup := fakeSetup(t)
+	defer cleanup()
+
+	release := fr.holdEvents("die")
+	created := createJob(t, 0, jobURL, `{"image":"alpine","cmds":[["true"],["echo","never"]]}`)
+	job := waitForJob(t, jobURL, created.ID, func(job *Job) bool { return len(job.Containers) == 1 })
+	for {
+		c, err := fr.InspectContainer(string(job.Containers[0]))
+		assert.NoError(t, err)
+		if !c.State.Running && !c.State.FinishedAt.IsZero() {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stopJob(t, 0, jobURL, created.ID)
+	job = waitForJob(t, jobURL, created.ID, isDone)
+	assert.Equal(t, JobStatusStopped, job.Status)
+
+	release()
+	job = waitForJob(t, jobURL, created.ID, func(job *Job) bool { return len(job.Images) == 1 })
+	assert.Equal(t, JobStatusStopped, job.Status, "The job should stay stopped once the container's death is handled")
+	assert.Equal(t, []CmdResult{0}, job.Results)
+	assert.Equal(t, 1, len(job.Containers), "No more commands should run once the job is stopped")
+}
+
+func TestFakeRuntimeStopWhilePulling(t *testing.T) {
+	jobURL, fr, cleanup := fakeSetup(t)
+	defer cleanup()
+
+	fr.delay("PullImage", time.Second)
+	created := createJob(t, 0, jobURL, `{"image":"alpine","cmds":[["echo","hi"]]}`)
+	waitForJob(t, jobURL, created.ID, func(job *Job) bool { return job.Status == JobStatusRunning })
+	stopJob(t, 0, jobURL, created.ID)
+
+	job := waitForJob(t, jobURL, created.ID, isDone)
+	assert.Equal(t, JobStatusStopped, job.Status)
+	assert.Equal(t, "Stopped while pulling image alpine", job.Message)
+	assert.Equal(t, 0, len(job.Containers))
+}
+
+// TestFakeRuntimeForgedJob submits a job naming things which aren't
+// its own, which mustn't be resumed from or cleaned up
+func TestFakeRuntimeForgedJob(t *testing.T) {
+	jobURL, fr, cleanup := fakeSetupWithConfig(t, Config{GCMaxAge: time.Nanosecond})
+	defer cleanup()
+
+	fr.addImage("postgres", nil, nil)
+	assert.NoError(t, fr.PullImage(docker.PullImageOptions{Repository: "postgres", Tag: "latest"}, docker.AuthConfiguration{}))
+	other, err := fr.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{Image: "postgres:latest", Cmd: []string{"true"}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	created := createJob(t, 0, jobURL, fmt.Sprintf(`{"image":"alpine","cmds":[["echo","hi"]],
+		"message":"forged","results":[0],"containers":[%[1]q],"images":["postgres:latest"],
+		"attempts":[{"containers":[%[1]q],"images":["postgres:latest"]}],
+		"removed_containers":["abc"],"image_id":"forged","start_time":"2016-01-01T00:00:00Z"}`, other.ID))
+	assert.Equal(t, 0, len(created.Containers), "Containers should be ignored on submit")
+	assert.Equal(t, 0, len(created.Attempts), "Attempts should be ignored on submit")
+	assert.True(t, created.StartTime.IsZero(), "Start time should be ignored on submit")
+
+	job := waitForJob(t, jobURL, created.ID, isDone)
+	assert.Equal(t, JobStatusSuccessful, job.Status)
+	assert.Equal(t, []CmdResult{0}, job.Results)
+	assert.Equal(t, "", job.Message)
+	assert.Equal(t, 1, len(job.Containers))
+	assert.NotEqual(t, Container(other.ID), job.Containers[0])
+	assert.Equal(t, "hi\n", getLogs(t, 0, jobURL, job.ID))
+
+	resp, err := http.Post(strings.TrimSuffix(jobURL, "/jobs")+"/admin/gc", "application/json", nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	job = getJob(t, 0, jobURL, job.ID)
+	assert.Equal(t, job.Containers, job.RemovedContainers, "The job's own container should be removed")
+	_, err = fr.InspectContainer(other.ID)
+	assert.NoError(t, err, "Containers outside the job should be left alone")
+	_, err = fr.InspectImage("postgres:latest")
+	assert.NoError(t, err, "Images outside the job should be left alone")
+
+	// even if a record does name things outside the job
+	labelled, _ := fr.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{Image: "postgres:latest", Cmd: []string{"true"}, Labels: containerLabels(42, 0)},
+	})
+	committed, _ := fr.CommitContainer(docker.CommitContainerOptions{Container: labelled.ID})
+	store := NewJobStore()
+	forged, _ := store.Add(Job{
+		Status:     JobStatusSuccessful,
+		Containers: []Container{Container(other.ID), Container(labelled.ID)},
+		Images:     []ImageName{"postgres:latest", ImageName(committed.ID)},
+	})
+	c := cleaner{client: fr, jobUpdater: NewJobUpdater(store, NewJobEventBus(10))}
+	report := newCleanupReport()
+	c.removeContainers(&forged, report)
+	c.removeImages(&forged, "", report)
+	assert.Equal(t, 0, len(report.Containers)+len(report.Images), "Nothing outside the job should be removed")
+	assert.Equal(t, 4, len(report.Errors))
+	_, err = fr.InspectContainer(labelled.ID)
+	assert.NoError(t, err, "Containers of other jobs should be left alone")
+	_, err = fr.InspectImage(committed.ID)
+	assert.NoError(t, err, "Images of other jobs should be left alone")
+}
+
+// TestFakeRuntimeRecordedImages checks results and images already
+// in a job's record are only trusted when resuming after a restart
+func TestFakeRuntimeRecordedImages(t *testing.T) {
+	fr := newFakeRuntime()
+	fr.addImage("alpine", nil, nil)
+	assert.NoError(t, fr.PullImage(docker.PullImageOptions{Repository: "alpine", Tag: "latest"}, docker.AuthConfiguration{}))
+	store := NewJobStore()
+	updater := NewJobUpdater(store, NewJobEventBus(10))
+
+	for _, resumed := range []bool{false, true} {
+		job, _ := store.Add(Job{
+			ImageName: "alpine",
+			Status:    JobStatusRunning,
+			Results:   []CmdResult{0},
+			Images:    []ImageName{"recorded"},
+		})
+		container, err := fr.CreateContainer(docker.CreateContainerOptions{
+			Config: &docker.Config{Image: "alpine", Cmd: []string{"true"}, Labels: containerLabels(job.ID, 0)},
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, fr.StartContainer(container.ID, nil))
+		fr.WaitContainer(container.ID)
+
+		jr := &jobRunner{
+			client:        fr,
+			job:           &job,
+			jobUpdater:    updater,
+			cmdChan:       make(chan interface{}, 2),
+			currContainer: container,
+			resumed:       resumed,
+		}
+		assert.NoError(t, jr.handleDieEvent(&docker.APIEvents{ID: container.ID, Status: "die", Time: time.Now().Unix()}))
+		assert.Equal(t, 1, jr.cmdIndex)
+		if resumed {
+			assert.Equal(t, []CmdResult{0}, job.Results, "The recorded result should be kept when resuming")
+			assert.Equal(t, "recorded", jr.prevImage.ID, "The recorded image should be used when resuming")
+		} else {
+			assert.Equal(t, []CmdResult{0, 0}, job.Results, "The result should always be added")
+			if assert.Equal(t, 2, len(job.Images), "The container should always be committed") {
+				assert.Equal(t, string(job.Images[1]), jr.prevImage.ID)
+			}
+		}
+	}
+}
+
+func TestFakeRuntimeResumeTimeout(t *testing.T) {
+	fr := newFakeRuntime()
+	fr.addImage("alpine", nil, nil)
+	fr.programs["block"] = func(p *fakeProcess) int {
+		<-p.Stopped
+		return 137
+	}
+	fr.PullImage(docker.PullImageOptions{Repository: "alpine", Tag: "latest"}, docker.AuthConfiguration{})
+	container, _ := fr.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{Image: "alpine", Cmd: []string{"block"}, Labels: containerLabels(0, 0)},
+	})
+	fr.StartContainer(container.ID, nil)
+
+	jobURL, ID, cleanup := restartSetup(t, fr, Job{
+		ImageName:  "alpine",
+		Cmds:       []Cmd{{Args: []string{"block"}}},
+		Timeout:    60,
+		Status:     JobStatusRunning,
+		Containers: []Container{Container(container.ID)},
+		StartTime:  time.Now().Add(-2 * time.Minute),
+	})
+	defer cleanup()
+
+	job := waitForJob(t, jobURL, ID, isDone)
+	assert.Equal(t, JobStatusTimedOut, job.Status, "A resumed job should only get what's left of its timeout")
+	assert.Equal(t, "Job exceeded its timeout of 60 seconds", job.Message)
+}
+
+func TestFakeRuntimeResumeNeverStarted(t *testing.T) {
+	fr := newFakeRuntime()
+	fr.addImage("alpine", nil, nil)
+	fr.PullImage(docker.PullImageOptions{Repository: "alpine", Tag: "latest"}, docker.AuthConfiguration{})
+	container, _ := fr.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{Image: "alpine", Cmd: []string{"true"}, Labels: containerLabels(0, 0)},
+	})
+
+	jobURL, ID, cleanup := restartSetup(t, fr, Job{
+		ImageName:  "alpine",
+		Cmds:       []Cmd{{Args: []string{"true"}}},
+		Status:     JobStatusRunning,
+		Containers: []Container{Container(container.ID)},
+	})
+	defer cleanup()
+
+	job := waitForJob(t, jobURL, ID, isDone)
+	assert.Equal(t, JobStatusSuccessful, job.Status, "A job whose container never started should be run again")
+	assert.Equal(t, []CmdResult{0}, job.Results)
+	if assert.Equal(t, 1, len(job.Attempts)) {
+		assert.Equal(t, []Container{Container(container.ID)}, job.Attempts[0].Containers)
+		assert.Equal(t, "Re-queued after restart, container "+container.ID+" never started", job.Attempts[0].Message)
+	}
+}
@@ -84,6 +84,39 @@ func getLogs(t *testing.T, tcNum int, jobURL string, jobID JobID) string {
 	return bodyToString(t, tcNum, resp.Body)
 }
 
+func getLogLines(t *testing.T, tcNum int, url string) []LogLine {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Errorf("Case %d: Error sending get log lines request: %s", tcNum, err)
+		return nil
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Case %d: Status code should be 200", tcNum)
+
+	lines := []LogLine{}
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		line := LogLine{}
+		if err := decoder.Decode(&line); err != nil {
+			t.Errorf("Case %d: Error decoding log line: %s", tcNum, err)
+			return lines
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func followLogs(t *testing.T, tcNum int, jobURL string, jobID JobID) string {
+	resp, err := http.Get(fmt.Sprintf("%s/%d/logs?follow=true", jobURL, jobID))
+	if err != nil {
+		t.Errorf("Case %d: Error sending follow logs request: %s", tcNum, err)
+		return ""
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Case %d: Status code should be 200", tcNum)
+
+	return bodyToString(t, tcNum, resp.Body)
+}
+
 // TODO: refactor these into one function
 func waitUntilDone(t *testing.T, tcNum int, jobURL string, jobID JobID) {
 	for i := 0; i < retryCount; i++ {
@@ -16,6 +16,7 @@ func NewStopEventListener(stopEventChan chan JobID) StopEventListener {
 		lock:      &sync.RWMutex{},
 		listeners: make(map[chan JobID]bool),
 		eventChan: stopEventChan,
+		quit:      make(chan bool),
 	}
 }
 
@@ -24,16 +25,30 @@ type stopEventListener struct {
 	listeners map[chan JobID]bool
 	running   bool
 	eventChan chan JobID
+	quit      chan bool
 }
 
 func (el *stopEventListener) Start() error {
-	// TODO: proper locking
+	el.lock.Lock()
+	defer el.lock.Unlock()
+	if el.running {
+		return nil
+	}
+	el.running = true
 	go el.eventWorker()
 	return nil
 }
 
 func (el *stopEventListener) Stop() {
-	// TODO: implement
+	el.lock.Lock()
+	running := el.running
+	el.running = false
+	el.lock.Unlock()
+	if running {
+		// the worker needs the lock to finish sending
+		// an event, so don't hold it while stopping it
+		el.quit <- true
+	}
 }
 
 func (el *stopEventListener) RegisterListener(listener chan JobID) {
@@ -50,12 +65,15 @@ func (el *stopEventListener) UnregisterListener(listener chan JobID) {
 
 func (el *stopEventListener) eventWorker() {
 	for {
-		for event := range el.eventChan {
+		select {
+		case event := <-el.eventChan:
 			el.lock.RLock()
 			for listener := range el.listeners {
 				go el.sendToListener(listener, event)
 			}
 			el.lock.RUnlock()
+		case <-el.quit:
+			return
 		}
 	}
 }
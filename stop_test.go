@@ -55,8 +55,8 @@ var stopTestCases = []testCase{
 		job: Job{
 			ImageName: "ubuntu:14.04",
 			Cmds: []Cmd{
-				[]string{"echo", "Sleeping..."},
-				[]string{"sleep", "30"},
+				Cmd{Args: []string{"echo", "Sleeping..."}},
+				Cmd{Args: []string{"sleep", "30"}},
 			},
 			Results: []CmdResult{0, 137},
 		},
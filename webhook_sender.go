@@ -4,11 +4,54 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/emicklei/go-restful"
 )
 
+// WebhookSender sends webhook requests in the background
+type WebhookSender interface {
+	Send(job Job)
+	// Flush waits up to the timeout for pending
+	// requests, returning whether they all finished
+	Flush(timeout time.Duration) bool
+}
+
+// NewWebhookSender returns a new WebhookSender
+func NewWebhookSender() WebhookSender {
+	return &webhookSender{
+		pending: &sync.WaitGroup{},
+	}
+}
+
+type webhookSender struct {
+	pending *sync.WaitGroup
+}
+
+func (ws *webhookSender) Send(job Job) {
+	ws.pending.Add(1)
+	go func() {
+		defer ws.pending.Done()
+		SendWebhook(job)
+	}()
+}
+
+func (ws *webhookSender) Flush(timeout time.Duration) bool {
+	done := make(chan bool)
+	go func() {
+		ws.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 // SendWebhook performs the webhook request for the given Job
 func SendWebhook(job Job) {
 	if job.WebhookURL == "" {
@@ -0,0 +1,26 @@
+package dockworker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookSenderFlush(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender := NewWebhookSender()
+	sender.Send(Job{ID: 1, WebhookURL: server.URL})
+	assert.False(t, sender.Flush(50*time.Millisecond), "Flush should time out while a request is pending")
+
+	close(release)
+	assert.True(t, sender.Flush(time.Second), "Flush should finish once the request is sent")
+}
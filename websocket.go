@@ -0,0 +1,103 @@
+package dockworker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsCloseNormal is the close code for a connection which is done
+	wsCloseNormal = websocket.CloseNormalClosure
+	// wsCloseTryAgainLater is the close code for a
+	// connection the client should open again
+	wsCloseTryAgainLater = websocket.CloseTryAgainLater
+
+	// the largest message accepted from a client
+	wsMaxMessageSize = 1 << 16
+	// how long writing a message may take before giving up on the client
+	wsWriteTimeout = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: checkWebSocketOrigin,
+	Error:       webSocketError,
+}
+
+// isWebSocketRequest returns whether the client asked to upgrade to a WebSocket
+func isWebSocketRequest(r *http.Request) bool {
+	return websocket.IsWebSocketUpgrade(r)
+}
+
+// checkWebSocketOrigin only lets browsers open WebSockets from
+// pages served by us, so other sites can't read the events of
+// jobs with the user's credentials. Other clients don't send
+// an Origin and are let through.
+func checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// webSocketError responds to a failed handshake the same way as other errors
+func webSocketError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse(reason.Error()))
+}
+
+// wsConn is a server side WebSocket connection
+// which pushes messages to the client
+type wsConn struct {
+	conn *websocket.Conn
+}
+
+// upgradeWebSocket completes the handshake and takes over the connection.
+// If it fails the client has already been sent an error response.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(wsMaxMessageSize)
+	return &wsConn{conn: conn}, nil
+}
+
+// WriteText sends a text message
+func (ws *wsConn) WriteText(data []byte) error {
+	ws.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return ws.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Ping sends a ping, which the client answers to show it's still there
+func (ws *wsConn) Ping() error {
+	return ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+}
+
+// Close sends a close message with the code and
+// reason and then closes the connection
+func (ws *wsConn) Close(code int, reason string) error {
+	ws.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+	return ws.conn.Close()
+}
+
+// ReadLoop reads from the client until the connection fails or the
+// client closes it, answering pings and close messages on the way.
+// Messages from clients are thrown away.
+func (ws *wsConn) ReadLoop() error {
+	for {
+		if _, _, err := ws.conn.NextReader(); err != nil {
+			return err
+		}
+	}
+}